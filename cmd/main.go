@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/runner"
 	sim2 "github.com/nntaoli-project/goex_backtest/sim"
 	"github.com/nntaoli-project/goex_backtest/strategies"
 	"log"
@@ -55,10 +56,24 @@ func main() {
 	}()
 
 	backtestStatistics := sim2.NewBacktestStatistics([]*sim2.ExchangeSim{sim})
+	taLibConfig := sim2.DefaultTaLibReportConfig
+	taLibConfig.MA = nil
+	taLibConfig.EMA = []int{150, 600} //与策略的均线一致
+	backtestStatistics.SetTaLibReportConfig(taLibConfig)
 
-	strategy := strategies.NewDoubleMovingStrategy(sim, goex.KLINE_PERIOD_1MIN, 600, 150, goex.BTC_USDT)
-	strategy.Main(ctx)
+	strategy := strategies.NewDoubleMovingStrategy(600, 150, goex.BTC_USDT)
+	backtestRunner := runner.NewRunner(sim, runner.Config{
+		Pairs:  []goex.CurrencyPair{goex.BTC_USDT},
+		Period: goex.KLINE_PERIOD_1MIN,
+	})
+	result, err := backtestRunner.Run(ctx, strategy)
+	if err != nil {
+		log.Println("backtest interrupted,", err)
+	}
+	log.Printf("###### events %d, orders %d, net asset %f -> %f, return %.4f%% ######",
+		result.Events, result.Orders, result.InitialNetAsset, result.FinalNetAsset, result.Return*100)
 
+	backtestStatistics.MetricsReport()
 	backtestStatistics.NetAssetReport()
 	backtestStatistics.OrderReport()
 	backtestStatistics.TaLibReport()
@@ -94,7 +94,7 @@ func (loader *DepthDataLoader) loadData(first bool) {
 		dep := goex.Depth{
 			ContractType: "",
 			Pair:         loader.Pair,
-			UTime:        time.Unix(goex.ToInt64(r[0])/1000, goex.ToInt64(r[0])%1000),
+			UTime:        time.Unix(goex.ToInt64(r[0])/1000, goex.ToInt64(r[0])%1000*int64(time.Millisecond)),
 		}
 
 		for i := 1; i < step+1; i += 2 {
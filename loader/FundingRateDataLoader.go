@@ -0,0 +1,96 @@
+package loader
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+//资金费率数据加载,文件格式: timestamp,rate (时间戳为秒或毫秒,不需要header)
+type FundingRateDataLoader struct {
+	model.DataConfig
+	data  []goex.HistoricalFunding
+	Index int
+}
+
+func NewFundingRateDataLoader(c model.DataConfig) *FundingRateDataLoader {
+	loader := &FundingRateDataLoader{
+		DataConfig: c,
+	}
+	loader.load()
+	return loader
+}
+
+func (loader *FundingRateDataLoader) load() {
+	//binance.com_funding_rate_btcusdt.csv
+	fileName := fmt.Sprintf("%s/%s_funding_rate_%s", dataBaseDir, loader.Ex, loader.Pair.ToLower().ToSymbol(""))
+	if loader.UnGzip {
+		fileName += ".gz"
+	} else {
+		fileName += ".csv"
+	}
+
+	log.Printf("###### begin load the %s ######", fileName)
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		log.Println("open file error", err)
+		return
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if loader.UnGzip {
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			log.Println("gzip read error", err)
+			return
+		}
+		reader = r
+	}
+
+	records, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	endTime := loader.EndTime.AddDate(0, 0, 1) //结束日期当天的数据也需要
+	for _, r := range records {
+		ts := goex.ToInt64(r[0])
+		if ts < 1e12 {
+			ts *= 1000
+		}
+		fundingTime := time.Unix(ts/1000, ts%1000*int64(time.Millisecond))
+		if fundingTime.Before(loader.StarTime) || !fundingTime.Before(endTime) {
+			continue
+		}
+		loader.data = append(loader.data, goex.HistoricalFunding{
+			InstrumentId: loader.Pair.ToSymbol("_"),
+			RealizedRate: goex.ToFloat64(r[1]),
+			FundingTime:  fundingTime,
+		})
+	}
+
+	sort.Slice(loader.data, func(i, j int) bool {
+		return loader.data[i].FundingTime.Before(loader.data[j].FundingTime)
+	})
+
+	log.Printf("###### end load , current size %d ######", len(loader.data))
+}
+
+//返回until之前(含)还未结算的资金费率
+func (loader *FundingRateDataLoader) Next(until time.Time) []goex.HistoricalFunding {
+	begin := loader.Index
+	for loader.Index < len(loader.data) && !loader.data[loader.Index].FundingTime.After(until) {
+		loader.Index++
+	}
+	return loader.data[begin:loader.Index]
+}
@@ -0,0 +1,38 @@
+package loader
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFundingRateDataLoader(t *testing.T) {
+	os.MkdirAll(dataBaseDir, 0755)
+	defer os.Remove(dataBaseDir)
+	fileName := dataBaseDir + "/test.com_funding_rate_btcusdt.csv"
+	ioutil.WriteFile(fileName, []byte("1583971200000,0.0001\n1583942400,-0.0002\n1584000000000,0.0003\n1584144000000,0.0004\n"), 0644)
+	defer os.Remove(fileName)
+
+	loader := NewFundingRateDataLoader(model.DataConfig{
+		Ex:       "test.com",
+		Pair:     goex.BTC_USDT,
+		StarTime: time.Unix(1583942400, 0),
+		EndTime:  time.Unix(1583942400, 0),
+	})
+
+	fundings := loader.Next(time.Unix(1583971200, 0))
+	assert.Len(t, fundings, 2)
+	assert.Equal(t, -0.0002, fundings[0].RealizedRate)
+	assert.Equal(t, 0.0001, fundings[1].RealizedRate)
+
+	assert.Len(t, loader.Next(time.Unix(1583971200, 0)), 0)
+
+	//超过回测结束日期的数据不加载
+	fundings = loader.Next(time.Unix(1584144000, 0))
+	assert.Len(t, fundings, 1)
+	assert.Equal(t, 0.0003, fundings[0].RealizedRate)
+}
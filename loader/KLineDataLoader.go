@@ -129,6 +129,20 @@ func (loader *KLineDataLoader) Next(pair goex.CurrencyPair, period goex.KlinePer
 	return klineData, nil
 }
 
+//已经推送过的K线,按时间从旧到新排列
+func (loader *KLineDataLoader) Loaded(pair goex.CurrencyPair, period goex.KlinePeriod) []goex.Kline {
+	for p, periods := range loader.data {
+		if !p.Eq(pair) || periods[period] == nil { //配置文件解析出的币对与goex定义的币对描述可能不同
+			continue
+		}
+		data := periods[period]
+		klines := make([]goex.Kline, data.Index)
+		copy(klines, data.Data[:data.Index])
+		return klines
+	}
+	return nil
+}
+
 func (loader *KLineDataLoader) adaptKlinePeriod(period goex.KlinePeriod) string {
 	switch period {
 	case goex.KLINE_PERIOD_1DAY:
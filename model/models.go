@@ -26,6 +26,33 @@ type ExchangeSimConfig struct {
 	DepthSize            int              //回测多少档深度
 	UnGzip               bool             //是否解压
 	BackTestData         BackTestDataType //回测数据类型
+	MarketOrderSlippage  float64          //K线回测时市价单相对收盘价的滑点比例,如0.001;配置了Slippage时不再使用
+	QueuePositionModel   bool             //深度回测时挂单按排队位置成交,而不是对手盘一碰到就成交
+	KlineVolumeRatio     float64          //K线回测时每根K线最多能成交其成交量的比例,0不限制
+	KlinePath            KlinePathType    //K线回测时K线内部的价格路径假设,默认开-高-低-收
+	Slippage             SlippageModel    //taker成交的滑点模型,nil不计滑点;K线回测的市价单优先使用它,nil时才按MarketOrderSlippage
+	VolatilityWindow     int              //滑点模型计算波动率使用的价格个数,默认20
+	OrderLatency         LatencyModel     //下单、撤单到达撮合引擎的延迟,nil为立即到达
+	OrderLatencyMs       int64            //未配置OrderLatency时使用的固定延迟(毫秒)
+	MarketDataLatency    time.Duration    //行情延迟,策略看到的行情比撮合引擎晚多久
+	//交易规则,key为币对,如BTC_USDT
+	TradingRules map[string]TradingRule
+
+	Leverage      float64 //合约回测的默认杠杆倍数,默认10
+	ContractValue float64 //合约回测每张合约对应的基础币数量,默认1
+
+	MaintenanceMarginRate float64 //合约回测的维持保证金率,默认0.005
+	LiquidationFeeRate    float64 //合约强平手续费率(按强平成交额),默认0.005
+
+	MarginTrade        bool    //现货杠杆:资产不足时自动借币,成交或撤单后自动还币
+	MarginLeverage     float64 //现货杠杆倍数,负债不超过净资产的(倍数-1)倍,默认3
+	MarginInterestRate float64 //借币的小时利率,如0.00002
+
+	WithdrawFee   map[string]float64 //跨交易所划转时的提币手续费,key为币种,如USDT
+	WithdrawDelay time.Duration      //跨交易所划转的到账时间
+
+	CostMethod       CostMethodType //成交配对成开平仓的方法,默认先进先出
+	SnapshotInterval time.Duration  //按回测时间自动记录净值快照的间隔,为0时只在策略调用AssetSnapshot时记录
 }
 
 type BackTestDataType int
@@ -34,3 +61,66 @@ const (
 	BackTestDataType_Depth BackTestDataType = iota + 1
 	BackTestDataType_KLine
 )
+
+type KlinePathType int
+
+const (
+	KlinePathType_OHLC KlinePathType = iota + 1 //开-高-低-收
+	KlinePathType_OLHC                          //开-低-高-收
+)
+
+type CostMethodType int
+
+const (
+	CostMethodType_FIFO    CostMethodType = iota + 1 //先进先出
+	CostMethodType_LIFO                              //后进先出
+	CostMethodType_Average                           //移动平均成本
+)
+
+//滑点模型,根据成交信息返回taker实际的成交价格
+type SlippageModel interface {
+	Price(ctx SlippageContext) float64
+}
+
+type SlippageContext struct {
+	Pair       goex.CurrencyPair
+	Side       goex.TradeSide
+	Price      float64 //不计滑点的成交价(盘口价或K线价)
+	Amount     float64 //成交数量
+	Volume     float64 //参考成交量,K线回测为当前K线成交量,深度回测为对手盘可见挂单总量
+	Volatility float64 //最近价格对数收益率的标准差
+}
+
+//延迟模型,每次调用返回一个请求的网络延迟
+type LatencyModel interface {
+	Delay() time.Duration
+}
+
+type TriggerOrderType int
+
+const (
+	TriggerOrderType_StopMarket       TriggerOrderType = iota + 1 //止损市价单
+	TriggerOrderType_StopLimit                                    //止损限价单
+	TriggerOrderType_TakeProfitMarket                             //止盈市价单
+	TriggerOrderType_TakeProfitLimit                              //止盈限价单
+	TriggerOrderType_TrailingStop                                 //跟踪止损单,触发后以市价单成交
+)
+
+//条件单参数
+type TriggerOrderParameter struct {
+	Type         TriggerOrderType
+	Side         goex.TradeSide
+	Amount       float64 //与普通订单一致,市价买单为计价币数量,其余为基础币数量
+	Price        float64 //限价单的委托价
+	TriggerPrice float64 //触发价,跟踪止损单不需要
+	CallbackRate float64 //跟踪止损单的回调比例,如0.01为1%
+}
+
+//交易所的下单规则,为0的项不限制
+type TradingRule struct {
+	PriceTick   float64 `toml:"price_tick"`   //价格精度
+	AmountStep  float64 `toml:"amount_step"`  //数量精度
+	MinAmount   float64 `toml:"min_amount"`   //最小下单数量
+	MaxAmount   float64 `toml:"max_amount"`   //最大下单数量
+	MinNotional float64 `toml:"min_notional"` //最小下单金额(计价币)
+}
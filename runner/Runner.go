@@ -0,0 +1,156 @@
+package runner
+
+import (
+	"context"
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/nntaoli-project/goex_backtest/sim"
+	"log"
+	"time"
+)
+
+type Config struct {
+	Pairs  []goex.CurrencyPair //K线回测推送的币对;深度回测推送交易所支持的全部币对
+	Period goex.KlinePeriod    //K线回测的K线周期
+}
+
+//回测结果
+type Result struct {
+	StartTime       int64 //第一个行情事件的时间戳(毫秒)
+	EndTime         int64 //最后一个行情事件的时间戳(毫秒)
+	Events          int   //推送的行情事件数
+	Orders          int   //策略下单数
+	OrderUpdates    int   //推送的订单状态变化次数
+	InitialNetAsset float64
+	FinalNetAsset   float64
+	Return          float64 //收益率
+	Elapsed         time.Duration
+}
+
+//回测驱动:按时间顺序从回测数据中推送行情给策略,转发撮合引擎的订单事件,
+//订单有成交时自动记录一行净值快照
+type Runner struct {
+	ex     *sim.ExchangeSim
+	config Config
+	events []*sim.OrderEvent //还未推送给策略的订单事件
+	result Result
+}
+
+func NewRunner(ex *sim.ExchangeSim, config Config) *Runner {
+	r := &Runner{
+		ex:     ex,
+		config: config,
+	}
+	ex.SubscribeOrderEvent(func(event *sim.OrderEvent) {
+		r.events = append(r.events, event)
+	})
+	return r
+}
+
+//运行回测直到数据结束,ctx取消时提前结束并返回ctx.Err()
+func (r *Runner) Run(ctx context.Context, strategy Strategy) (*Result, error) {
+	begin := time.Now()
+	r.result = Result{InitialNetAsset: r.ex.NetAsset()}
+
+	strategy.OnStart(r.ex)
+	r.dispatch(strategy)
+
+	var err error
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		default:
+		}
+
+		if !r.step(strategy) {
+			break
+		}
+	}
+
+	strategy.OnFinish()
+	r.dispatch(strategy)
+	r.ex.AssetSnapshot()
+
+	r.result.FinalNetAsset = r.ex.NetAsset()
+	if r.result.InitialNetAsset != 0 {
+		r.result.Return = r.result.FinalNetAsset/r.result.InitialNetAsset - 1
+	}
+	r.result.Elapsed = time.Now().Sub(begin)
+
+	result := r.result
+	return &result, err
+}
+
+//推送下一个行情事件,数据结束时返回false
+func (r *Runner) step(strategy Strategy) bool {
+	switch r.ex.BackTestDataType() {
+	case model.BackTestDataType_KLine:
+		var klines []goex.Kline
+		for _, pair := range r.config.Pairs {
+			data, err := r.ex.GetKlineRecords(pair, r.config.Period, 1)
+			if err != nil {
+				log.Println("kline data finished,", pair, err)
+				return false
+			}
+			klines = append(klines, data[0])
+		}
+		if len(klines) == 0 {
+			return false
+		}
+
+		r.dispatch(strategy)
+		for _, kline := range klines {
+			ts := kline.Timestamp
+			if ts < 1e12 { //K线数据的时间戳为秒
+				ts *= 1000
+			}
+			r.onEvent(ts)
+			strategy.OnKline(kline)
+			r.dispatch(strategy)
+		}
+	default:
+		depth, err := r.ex.NextDepth()
+		if err != nil {
+			return false
+		}
+
+		r.dispatch(strategy)
+		r.onEvent(depth.UTime.UnixNano() / int64(time.Millisecond))
+		strategy.OnDepth(*depth)
+		r.dispatch(strategy)
+	}
+	return true
+}
+
+func (r *Runner) onEvent(ts int64) {
+	if r.result.Events == 0 {
+		r.result.StartTime = ts
+	}
+	r.result.EndTime = ts
+	r.result.Events++
+}
+
+//在策略回调之外推送订单事件,回调中新产生的事件继续推送,有成交时记录净值快照
+func (r *Runner) dispatch(strategy Strategy) {
+	filled := false
+	for len(r.events) > 0 {
+		events := r.events
+		r.events = nil
+		for _, event := range events {
+			if event.Type == sim.OrderEventType_New {
+				r.result.Orders++
+			}
+			if event.FillAmount > 0 {
+				filled = true
+			}
+			r.result.OrderUpdates++
+			strategy.OnOrderUpdate(event.Order)
+		}
+	}
+	if filled {
+		r.ex.AssetSnapshot()
+	}
+}
@@ -0,0 +1,92 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/nntaoli-project/goex_backtest/sim"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+type testStrategy struct {
+	api     goex.API
+	klines  []goex.Kline
+	updates []goex.Order
+	finish  bool
+}
+
+func (s *testStrategy) OnStart(api goex.API) {
+	s.api = api
+}
+
+func (s *testStrategy) OnKline(kline goex.Kline) {
+	s.klines = append(s.klines, kline)
+	if len(s.klines) == 1 {
+		s.api.LimitBuy("1", "6950", kline.Pair)
+	}
+}
+
+func (s *testStrategy) OnDepth(depth goex.Depth) {
+}
+
+func (s *testStrategy) OnOrderUpdate(order goex.Order) {
+	s.updates = append(s.updates, order)
+}
+
+func (s *testStrategy) OnFinish() {
+	s.finish = true
+}
+
+func TestRunner_Kline(t *testing.T) {
+	os.MkdirAll("data", 0755)
+	defer os.Remove("data")
+	fileName := "data/runner.test_kline_btcusdt_1min_2020-03-12.csv"
+	ioutil.WriteFile(fileName, []byte("1583971200,7010,6980,6990,7000,10\n"+
+		"1583971260,7000,6940,7000,6960,10\n"+
+		"1583971320,7100,6960,6960,7050,10\n"), 0644)
+	defer os.Remove(fileName)
+	defer os.Remove(fmt.Sprintf(sim.AssetSnapshotCsvFileName, "runner.test"))
+	defer os.Remove(fmt.Sprintf(sim.FillsCsvFileName, "runner.test"))
+
+	ex := sim.NewExchangeSim(model.ExchangeSimConfig{
+		ExName:               "runner.test",
+		QuoteCurrency:        goex.USDT,
+		SupportCurrencyPairs: []goex.CurrencyPair{goex.BTC_USDT},
+		Account: goex.Account{
+			SubAccounts: map[goex.Currency]goex.SubAccount{
+				goex.BTC:  {Currency: goex.BTC},
+				goex.USDT: {Currency: goex.USDT, Amount: 10000},
+			},
+		},
+		BackTestStartTime: time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC),
+		BackTestEndTime:   time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC),
+		BackTestData:      model.BackTestDataType_KLine,
+	})
+
+	strategy := new(testStrategy)
+	result, err := NewRunner(ex, Config{
+		Pairs:  []goex.CurrencyPair{goex.BTC_USDT},
+		Period: goex.KLINE_PERIOD_1MIN,
+	}).Run(context.Background(), strategy)
+	assert.Nil(t, err)
+	assert.True(t, strategy.finish)
+	assert.Len(t, strategy.klines, 3)
+
+	//第二根K线最低价触及挂单价
+	assert.Len(t, strategy.updates, 2)
+	assert.Equal(t, goex.ORDER_UNFINISH, strategy.updates[0].Status)
+	assert.Equal(t, goex.ORDER_FINISH, strategy.updates[1].Status)
+	assert.Equal(t, 6950.0, strategy.updates[1].AvgPrice)
+
+	assert.Equal(t, 3, result.Events)
+	assert.Equal(t, 1, result.Orders)
+	assert.Equal(t, int64(1583971200000), result.StartTime)
+	assert.Equal(t, int64(1583971320000), result.EndTime)
+	assert.Equal(t, 10000.0, result.InitialNetAsset)
+	assert.InDelta(t, 10000+7050-6950, result.FinalNetAsset, 1e-8)
+}
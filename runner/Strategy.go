@@ -0,0 +1,13 @@
+package runner
+
+import "github.com/nntaoli-project/goex"
+
+//事件驱动的策略,由Runner按时间顺序推送行情和订单状态变化。
+//行情由Runner推进,回调中不要再调用GetDepth、GetKlineRecords
+type Strategy interface {
+	OnStart(api goex.API)           //回测开始,api用于下单和查询
+	OnKline(kline goex.Kline)       //K线回测,每根新K线
+	OnDepth(depth goex.Depth)       //深度回测,每个新的深度快照
+	OnOrderUpdate(order goex.Order) //策略订单的状态或成交数量发生变化
+	OnFinish()                      //回测数据结束或被取消
+}
@@ -10,15 +10,37 @@ import (
 )
 
 type BacktestStatistics struct {
-	sims []*ExchangeSim
+	sims        []*ExchangeSim
+	futureSims  []*FutureExchangeSim
+	coordinator *Coordinator
+	taLibConfig TaLibReportConfig
 }
 
-func NewBacktestStatistics(sims []*ExchangeSim) *BacktestStatistics {
+func NewBacktestStatistics(sims []*ExchangeSim, futureSims ...*FutureExchangeSim) *BacktestStatistics {
 	return &BacktestStatistics{
-		sims: sims,
+		sims:        sims,
+		futureSims:  futureSims,
+		taLibConfig: DefaultTaLibReportConfig,
 	}
 }
 
+//跨交易所回测时增加合计净值的曲线和指标
+func (s *BacktestStatistics) SetCoordinator(c *Coordinator) {
+	s.coordinator = c
+}
+
+//每个模拟交易所的净值快照文件
+func (s *BacktestStatistics) assetSnapshotFiles() []string {
+	var files []string
+	for _, ex := range s.sims {
+		files = append(files, fmt.Sprintf(AssetSnapshotCsvFileName, ex.GetExchangeName()))
+	}
+	for _, ex := range s.futureSims {
+		files = append(files, fmt.Sprintf(FutureAssetSnapshotCsvFileName, ex.GetExchangeName()))
+	}
+	return files
+}
+
 func (s *BacktestStatistics) NetAssetReport() {
 	lineChart := charts.NewLine()
 
@@ -31,34 +53,43 @@ func (s *BacktestStatistics) NetAssetReport() {
 			//Bottom:   "150px",
 		},
 		charts.InitOpts{PageTitle: "净值", Width: "1080px"},
+		charts.TooltipOpts{Show: true, Trigger: "axis"},
+		charts.XAxisOpts{Type: "time"},
 		charts.YAxisOpts{SplitLine: charts.SplitLineOpts{Show: true}, Scale: true},
+		charts.DataZoomOpts{Type: "slider", Start: 0, End: 100},
+		charts.DataZoomOpts{Type: "inside", Start: 0, End: 100},
 	)
 
-	for _, ex := range s.sims {
-		assetSnapshotFile, err := os.Open(fmt.Sprintf("%s_asset_snapshot.csv", ex.GetExchangeName()))
+	for i, file := range s.assetSnapshotFiles() {
+		assetSnapshotFile, err := os.Open(file)
 		if err != nil {
-			log.Printf("[ERROR] not found the %s  asset snapshot file ,error=%s", ex.GetExchangeName(), err)
+			log.Printf("[ERROR] not found the asset snapshot file %s ,error=%s", file, err)
 			continue
 		}
 		csvR := csv.NewReader(assetSnapshotFile)
 		records, err := csvR.ReadAll()
+		assetSnapshotFile.Close()
 		if err != nil {
-			log.Printf("[ERROR] read the %s asset snapshot file error=%s", ex.GetExchangeName(), err)
+			log.Printf("[ERROR] read the asset snapshot file %s error=%s", file, err)
+			continue
+		}
+		if len(records) < 2 {
 			continue
 		}
 
-		var (
-			netAsset []float64
-			xData    []int
+		lineChart.AddYAxis(s.exchangeName(i), netAssetSeries(records),
+			charts.MPNameTypeItem{Name: "最大值", Type: "max"},
+			charts.MPNameTypeItem{Name: "最小值", Type: "min"},
+			charts.MPStyleOpts{Label: charts.LabelTextOpts{Show: true}},
 		)
-		for i, record := range records[1:] {
-			curNetAsset := goex.ToFloat64(record[len(record)-1])
-			netAsset = append(netAsset, curNetAsset)
-			xData = append(xData, i)
-		}
+	}
 
-		lineChart.AddXAxis(xData)
-		lineChart.AddYAxis("净值", netAsset,
+	if s.coordinator != nil {
+		var series [][2]interface{}
+		for _, point := range s.coordinator.EquityCurve() {
+			series = append(series, [2]interface{}{point.Timestamp, point.NetAsset})
+		}
+		lineChart.AddYAxis(CombinedExName, series,
 			charts.MPNameTypeItem{Name: "最大值", Type: "max"},
 			charts.MPNameTypeItem{Name: "最小值", Type: "min"},
 			charts.MPStyleOpts{Label: charts.LabelTextOpts{Show: true}},
@@ -69,10 +100,80 @@ func (s *BacktestStatistics) NetAssetReport() {
 	lineChart.Render(netAssetF)
 }
 
-func (s *BacktestStatistics) OrderReport() {
+//第i个净值快照文件对应的交易所名称,顺序与assetSnapshotFiles一致
+func (s *BacktestStatistics) exchangeName(i int) string {
+	if i < len(s.sims) {
+		return s.sims[i].GetExchangeName()
+	}
+	return s.futureSims[i-len(s.sims)].GetExchangeName()
+}
 
+//快照的[回测时间(毫秒),净值],第一列不是Timestamp的旧快照文件用行号代替时间
+func netAssetSeries(records [][]string) [][2]interface{} {
+	timestamped := records[0][0] == "Timestamp"
+	var series [][2]interface{}
+	for i, record := range records[1:] {
+		var x interface{} = i
+		if timestamped {
+			x = goex.ToInt64(record[0])
+		}
+		series = append(series, [2]interface{}{x, goex.ToFloat64(record[len(record)-1])})
+	}
+	return series
+}
+
+//每个模拟交易所的收益风险指标,设置了Coordinator时最后一个为合计净值的指标
+func (s *BacktestStatistics) Metrics() []*Metrics {
+	var metrics []*Metrics
+	for _, ex := range s.sims {
+		metrics = append(metrics, ex.Metrics())
+	}
+	for _, ex := range s.futureSims {
+		metrics = append(metrics, ex.Metrics())
+	}
+	if s.coordinator != nil {
+		metrics = append(metrics, s.coordinator.Metrics())
+	}
+	return metrics
 }
 
-func (s *BacktestStatistics) TaLibReport() {
+//输出指标汇总表
+func (s *BacktestStatistics) MetricsReport() []*Metrics {
+	metrics := s.Metrics()
+	log.Printf("###### metrics ######\n%s", metricsTable(metrics))
+	return metrics
+}
 
+//每个模拟交易所的订单统计
+func (s *BacktestStatistics) OrderStats() []*OrderStats {
+	var stats []*OrderStats
+	for _, ex := range s.sims {
+		stats = append(stats, ex.orderStats())
+	}
+	for _, ex := range s.futureSims {
+		stats = append(stats, ex.engine.orderStats())
+	}
+	return stats
+}
+
+//订单明细写入%s_orders.csv,配对交易写入%s_round_trips.csv,统计和明细输出到order_report.html
+func (s *BacktestStatistics) OrderReport() {
+	stats := s.OrderStats()
+	for _, st := range stats {
+		st.writeCsv()
+		st.writeRoundTripsCsv()
+		log.Printf("[%s] orders %d, fill ratio %.2f%%, cancel ratio %.2f%%, maker ratio %.2f%%, fees %v",
+			st.ExName, st.Total, st.FillRatio*100, st.CancelRatio*100, st.MakerRatio*100, st.Fees)
+	}
+
+	reportF, err := os.OpenFile(OrderReportHtmlFile, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0744)
+	if err != nil {
+		log.Printf("[ERROR] create the order report file error=%s", err)
+		return
+	}
+	defer reportF.Close()
+
+	if err := orderReportTemplate.Execute(reportF, stats); err != nil {
+		log.Printf("[ERROR] render the order report error=%s", err)
+	}
 }
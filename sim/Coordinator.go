@@ -0,0 +1,239 @@
+package sim
+
+import (
+	"errors"
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/util"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const CombinedExName = "combined" //合计净值的指标和净值曲线名称
+
+var (
+	TransferCurrencyError = errors.New("currency not supported by the exchange")
+	TransferAmountError   = errors.New("transfer amount must be greater than withdraw fee")
+)
+
+//跨交易所划转记录,到账数量为Amount-Fee
+type Transfer struct {
+	Id          string
+	From        string
+	To          string
+	Currency    goex.Currency
+	Amount      float64 //从转出交易所扣除的数量
+	Fee         float64 //提币手续费
+	RequestTime int64
+	ArriveTime  int64
+	Arrived     bool
+}
+
+//跨交易所回测协调器(深度回测):多个ExchangeSim共用一个行情时钟,策略读取任意交易所的深度时,
+//所有交易所更早的行情先按时间顺序推送撮合,各交易所看到的价格在时间上是一致的;
+//同时模拟交易所之间的资产划转(提币手续费、到账延迟),在途资产不计入任何一个交易所的净值,
+//但计入所有交易所的合计净值,划转期间合计净值不会出现虚假的回撤
+type Coordinator struct {
+	*sync.Mutex
+	sims      []*ExchangeSim
+	clock     *marketClock
+	transfers []*Transfer
+	dests     map[string]*ExchangeSim //划转id对应的转入交易所
+	idGen     *util.IdGen
+	equity    []EquityPoint //合计净值曲线,每分钟一个点
+}
+
+func NewCoordinator(sims ...*ExchangeSim) *Coordinator {
+	c := &Coordinator{
+		Mutex: new(sync.Mutex),
+		sims:  sims,
+		clock: new(marketClock),
+		dests: make(map[string]*ExchangeSim, 10),
+		idGen: util.NewIdGen("transfer"),
+	}
+
+	for _, sim := range sims {
+		c.clock.streams = append(c.clock.streams, sim.clock.streams...)
+		sim.clock = c.clock
+	}
+	c.clock.beforeEvent = c.settleTransfers
+	c.clock.afterEvent = c.recordEquity
+
+	return c
+}
+
+//推进全局时钟一个行情事件,返回行情所属的交易所,所有交易所的数据都已结束时返回DataFinishedError
+func (c *Coordinator) Next() (*ExchangeSim, *goex.Depth, error) {
+	sim, depth := c.clock.next()
+	if depth == nil {
+		return nil, nil, DataFinishedError
+	}
+	return sim, depth, nil
+}
+
+//全局时钟的时间戳(毫秒)
+func (c *Coordinator) Now() int64 {
+	return atomic.LoadInt64(&c.clock.now)
+}
+
+func (c *Coordinator) Exchanges() []*ExchangeSim {
+	return c.sims
+}
+
+//从from划转amount到to,按from配置的WithdrawFee扣除提币手续费,WithdrawDelay之后的第一个行情事件到账,没有延迟时立即到账
+func (c *Coordinator) Transfer(from, to *ExchangeSim, currency goex.Currency, amount float64) (*Transfer, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	to.RLock()
+	_, ok := to.acc.SubAccounts[currency]
+	to.RUnlock()
+	if !ok || from == to {
+		return nil, TransferCurrencyError
+	}
+
+	fee := from.withdrawFee[strings.ToUpper(currency.Symbol)]
+	if amount <= fee {
+		return nil, TransferAmountError
+	}
+
+	from.Lock()
+	sub, ok := from.acc.SubAccounts[currency]
+	if !ok {
+		from.Unlock()
+		return nil, TransferCurrencyError
+	}
+	if sub.Amount < amount {
+		from.Unlock()
+		return nil, InsufficientError
+	}
+	sub.Amount -= amount
+	from.acc.SubAccounts[currency] = sub
+	from.Unlock()
+
+	now := c.Now()
+	t := &Transfer{
+		Id:          c.idGen.Get(),
+		From:        from.GetExchangeName(),
+		To:          to.GetExchangeName(),
+		Currency:    currency,
+		Amount:      amount,
+		Fee:         fee,
+		RequestTime: now,
+		ArriveTime:  now + int64(from.withdrawDelay/time.Millisecond),
+	}
+	c.transfers = append(c.transfers, t)
+	c.dests[t.Id] = to
+
+	if from.withdrawDelay <= 0 {
+		c.arrive(t)
+	}
+
+	result := *t
+	return &result, nil
+}
+
+//到账时间不晚于ts的划转入账
+func (c *Coordinator) settleTransfers(ts int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, t := range c.transfers {
+		if !t.Arrived && t.ArriveTime <= ts {
+			c.arrive(t)
+		}
+	}
+}
+
+func (c *Coordinator) arrive(t *Transfer) {
+	to := c.dests[t.Id]
+	to.Lock()
+	defer to.Unlock()
+
+	sub := to.acc.SubAccounts[t.Currency]
+	sub.Amount += t.Amount - t.Fee
+	to.acc.SubAccounts[t.Currency] = sub
+	to.autoRepay(t.Currency)
+
+	t.Arrived = true
+	delete(c.dests, t.Id)
+}
+
+func (c *Coordinator) GetTransfers() []Transfer {
+	c.Lock()
+	defer c.Unlock()
+
+	transfers := make([]Transfer, 0, len(c.transfers))
+	for _, t := range c.transfers {
+		transfers = append(transfers, *t)
+	}
+	return transfers
+}
+
+//所有交易所的合计净值,在途的划转按到账数量和转入交易所的估值价格计入
+func (c *Coordinator) NetAsset() float64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.netAsset()
+}
+
+func (c *Coordinator) netAsset() float64 {
+	netAsset := 0.0
+	for _, sim := range c.sims {
+		netAsset += sim.NetAsset()
+	}
+
+	for _, t := range c.transfers {
+		if t.Arrived {
+			continue
+		}
+		to := c.dests[t.Id]
+		to.RLock()
+		price, ok := to.valuationPrice(t.Currency)
+		to.RUnlock()
+		if !ok {
+			continue
+		}
+		netAsset += (t.Amount - t.Fee) * price
+	}
+	return netAsset
+}
+
+func (c *Coordinator) recordEquity(ts int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.equity = appendEquity(c.equity, EquityPoint{Timestamp: ts, NetAsset: c.netAsset()})
+}
+
+//合计净值曲线,最后一个点为当前合计净值
+func (c *Coordinator) EquityCurve() []EquityPoint {
+	c.Lock()
+	defer c.Unlock()
+
+	equity := make([]EquityPoint, len(c.equity), len(c.equity)+1)
+	copy(equity, c.equity)
+	now := c.Now()
+	if n := len(equity); n > 0 && equity[n-1].Timestamp < now {
+		equity = append(equity, EquityPoint{Timestamp: now, NetAsset: c.netAsset()})
+	}
+	return equity
+}
+
+//按合计净值曲线和所有交易所的配对交易计算的指标
+func (c *Coordinator) Metrics() *Metrics {
+	var (
+		trips    []RoundTrip
+		held     int64
+		notional float64
+	)
+	for _, sim := range c.sims {
+		sim.RLock()
+		trips = append(trips, sim.tracker.roundTrips...)
+		held += sim.tracker.heldTime(sim.currTime)
+		notional += sim.tracker.notional
+		sim.RUnlock()
+	}
+	return calcMetrics(CombinedExName, c.EquityCurve(), trips, held, notional)
+}
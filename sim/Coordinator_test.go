@@ -0,0 +1,102 @@
+package sim
+
+import (
+	"fmt"
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestCoordinatorSim(exName string, withdrawFee float64, withdrawDelay time.Duration) *ExchangeSim {
+	return newExchangeSim(model.ExchangeSimConfig{
+		ExName:               exName,
+		QuoteCurrency:        goex.USDT,
+		SupportCurrencyPairs: []goex.CurrencyPair{goex.BTC_USDT},
+		Account: goex.Account{
+			SubAccounts: map[goex.Currency]goex.SubAccount{
+				goex.BTC:  {Currency: goex.BTC},
+				goex.USDT: {Currency: goex.USDT, Amount: 1000},
+			},
+		},
+		BackTestStartTime: time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC),
+		BackTestEndTime:   time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC),
+		BackTestData:      model.BackTestDataType_Depth,
+		DepthSize:         1,
+		WithdrawFee:       map[string]float64{"usdt": withdrawFee},
+		WithdrawDelay:     withdrawDelay,
+	})
+}
+
+func TestCoordinator(t *testing.T) {
+	os.MkdirAll("data", 0755)
+	defer os.Remove("data")
+	files := map[string]string{
+		"coord.a": "1583971200000,7001,1,7000,1\n1583971202000,7101,1,7100,1\n",
+		"coord.b": "1583971201000,7051,1,7050,1\n1583971203000,7061,1,7060,1\n",
+	}
+	for ex, data := range files {
+		fileName := fmt.Sprintf("data/%s_btcusdt_2020-03-12.csv", ex)
+		ioutil.WriteFile(fileName, []byte(data), 0644)
+		defer os.Remove(fileName)
+	}
+
+	a := newTestCoordinatorSim("coord.a", 0, 0)
+	b := newTestCoordinatorSim("coord.b", 1, 1500*time.Millisecond)
+	c := NewCoordinator(a, b)
+
+	a.GetDepth(1, goex.BTC_USDT)
+	b.GetDepth(1, goex.BTC_USDT)
+	assert.Equal(t, int64(1583971201000), c.Now())
+
+	_, err := c.Transfer(b, a, goex.USDT, 1)
+	assert.Equal(t, TransferAmountError, err)
+	_, err = c.Transfer(b, a, goex.USDT, 2000)
+	assert.Equal(t, InsufficientError, err)
+
+	transfer, err := c.Transfer(b, a, goex.USDT, 101)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1583971202500), transfer.ArriveTime)
+	acc, _ := b.GetAccount()
+	assert.Equal(t, 899.0, acc.SubAccounts[goex.USDT].Amount)
+
+	//到账之前,在途的划转计入合计净值
+	depth, _ := a.GetDepth(1, goex.BTC_USDT)
+	assert.Equal(t, 7100.0, depth.BidList[0].Price)
+	acc, _ = a.GetAccount()
+	assert.Equal(t, 1000.0, acc.SubAccounts[goex.USDT].Amount)
+	assert.Equal(t, 1999.0, c.NetAsset())
+
+	ex, depth, err := c.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, b, ex)
+	assert.Equal(t, 7060.0, depth.BidList[0].Price)
+	acc, _ = a.GetAccount()
+	assert.Equal(t, 1100.0, acc.SubAccounts[goex.USDT].Amount)
+	assert.True(t, c.GetTransfers()[0].Arrived)
+
+	//没有到账延迟时立即到账
+	c.Transfer(a, b, goex.USDT, 100)
+	acc, _ = b.GetAccount()
+	assert.Equal(t, 999.0, acc.SubAccounts[goex.USDT].Amount)
+
+	_, _, err = c.Next()
+	assert.Equal(t, DataFinishedError, err)
+
+	//合计净值只因提币手续费减少
+	equity := c.EquityCurve()
+	assert.Equal(t, 2000.0, equity[0].NetAsset)
+	assert.Equal(t, 1999.0, equity[len(equity)-1].NetAsset)
+	m := c.Metrics()
+	assert.Equal(t, CombinedExName, m.ExName)
+	assert.InDelta(t, 1.0/2000, m.MaxDrawdown, 1e-12)
+
+	s := NewBacktestStatistics([]*ExchangeSim{a, b})
+	s.SetCoordinator(c)
+	metrics := s.Metrics()
+	assert.Len(t, metrics, 3)
+	assert.Equal(t, m, metrics[2])
+}
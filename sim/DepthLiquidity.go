@@ -0,0 +1,42 @@
+package sim
+
+import "github.com/nntaoli-project/goex"
+
+//depthLiquidity 记录当前深度快照中各价位已被模拟成交消耗掉的数量,
+//同一快照内的多个订单(或同一订单多次撮合)不会重复吃到同一份流动性,收到下一个深度快照时清零
+type depthLiquidity struct {
+	asks map[float64]float64
+	bids map[float64]float64
+}
+
+func newDepthLiquidity() *depthLiquidity {
+	return &depthLiquidity{
+		asks: make(map[float64]float64, 20),
+		bids: make(map[float64]float64, 20),
+	}
+}
+
+func (l *depthLiquidity) reset() {
+	l.asks = make(map[float64]float64, 20)
+	l.bids = make(map[float64]float64, 20)
+}
+
+func (l *depthLiquidity) consumed(side goex.TradeSide) map[float64]float64 {
+	if side == goex.BUY { //买单消耗卖盘
+		return l.asks
+	}
+	return l.bids
+}
+
+//taker方向为side的订单在该档位还能成交的数量
+func (l *depthLiquidity) available(side goex.TradeSide, level goex.DepthRecord) float64 {
+	remain := level.Amount - l.consumed(side)[level.Price]
+	if remain < 0 {
+		return 0
+	}
+	return remain
+}
+
+func (l *depthLiquidity) consume(side goex.TradeSide, price, amount float64) {
+	l.consumed(side)[price] += amount
+}
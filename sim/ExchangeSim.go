@@ -31,14 +31,48 @@ type ExchangeSim struct {
 	name                 string
 	makerFee             float64
 	takerFee             float64
+	marketOrderSlippage  float64
+	slippage             model.SlippageModel
+	volatilityWindow     int
 	supportCurrencyPairs []goex.CurrencyPair
 	quoteCurrency        goex.Currency
 	pendingOrders        map[string]*goex.Order
 	finishedOrders       map[string]*goex.Order
-	depthLoader          map[goex.CurrencyPair]*loader.DepthDataLoader
+	triggerOrders        map[string]*triggerOrder //还未触发的条件单
+	tradingRules         map[string]model.TradingRule
+	marginTrade          bool
+	marginLeverage       float64
+	marginInterestRate   float64 //每小时利率
+	nextInterestTime     int64   //下次计息时间(毫秒)
+	withdrawFee          map[string]float64
+	withdrawDelay        time.Duration
+	clock                *marketClock
 	klineLoader          *loader.KLineDataLoader
-	currKline            goex.Kline
-	currDepth            goex.Depth
+	klineVolumeRatio     float64
+	klinePath            model.KlinePathType
+	pathLimit            float64                 //K线回测只撮合价格路径上不晚于该位置触及的挂单和条件单
+	pathCheck            func(point int) bool    //沿K线价格路径逐点撮合后调用,返回true时停止撮合
+	markets              map[string]*marketState //每个币对当前的行情
+	currTime             int64                   //最新行情事件的时间戳(毫秒)
+	lastPrices           map[string]float64      //每个币对最新的估值价格
+	queueModel           bool
+	queueAhead           map[string]float64 //挂单前方还在排队的数量
+	orderLatency         model.LatencyModel
+	marketDataLatency    time.Duration
+	requests             []*orderRequest  //还未到达撮合引擎的下单、撤单请求
+	unarrived            map[string]bool  //还未到达撮合引擎的订单
+	cancelling           map[string]bool  //撤单请求还未到达的订单,对外显示为撤单中
+	cancelErrors         map[string]error //延迟到达时失败的撤单
+	ledger               assetLedger      //资产记账,默认为现货账户
+	orderSubscribers     []func(event *OrderEvent)
+	orderEvents          []*OrderEvent //还未推送给订阅者的订单事件
+	flushingEvents       bool
+	fills                []Fill
+	equity               []EquityPoint //净值曲线
+	tracker              *PositionTracker
+	fillsCsvFile         string //成交记录文件,撮合引擎不写文件
+	snapshotInterval     int64  //自动净值快照间隔(毫秒)
+	nextSnapshotTime     int64
 	idGen                *util.IdGen
 
 	sortedCurrencies []goex.Currency
@@ -47,18 +81,72 @@ type ExchangeSim struct {
 }
 
 func NewExchangeSim(config model.ExchangeSimConfig) *ExchangeSim {
+	sim := newExchangeSim(config)
+
+	header := []string{"Timestamp"}
+	for _, c := range sim.sortedCurrencies {
+		header = append(header, fmt.Sprintf("%s_available", c.Symbol))
+		header = append(header, fmt.Sprintf("%s_frozen", c.Symbol))
+		if sim.marginTrade {
+			header = append(header, fmt.Sprintf("%s_loan", c.Symbol))
+		}
+	}
+	header = append(header, "NetAsset")
+
+	csvFile := fmt.Sprintf(AssetSnapshotCsvFileName, sim.name)
+	f, err := os.OpenFile(csvFile, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0744)
+	if err != nil {
+		panic(err)
+	}
+
+	csvW := csv.NewWriter(f)
+	csvW.Write(header)
+	csvW.Flush()
+	f.Close()
+
+	sim.fillsCsvFile = createFillsCsv(sim.name)
+
+	return sim
+}
+
+//撮合引擎,不创建净值快照文件
+func newExchangeSim(config model.ExchangeSimConfig) *ExchangeSim {
 	sim := &ExchangeSim{
 		RWMutex:              new(sync.RWMutex),
 		idGen:                util.NewIdGen(config.ExName),
 		name:                 config.ExName,
 		makerFee:             config.MakerFee,
 		takerFee:             config.TakerFee,
+		marketOrderSlippage:  config.MarketOrderSlippage,
+		slippage:             config.Slippage,
+		volatilityWindow:     config.VolatilityWindow,
 		acc:                  &config.Account,
 		supportCurrencyPairs: config.SupportCurrencyPairs,
 		quoteCurrency:        config.QuoteCurrency,
 		pendingOrders:        make(map[string]*goex.Order, 100),
 		finishedOrders:       make(map[string]*goex.Order, 100),
-		depthLoader:          make(map[goex.CurrencyPair]*loader.DepthDataLoader, 1),
+		markets:              make(map[string]*marketState, 2),
+		lastPrices:           make(map[string]float64, 2),
+		triggerOrders:        make(map[string]*triggerOrder, 10),
+		tradingRules:         newTradingRules(config.TradingRules),
+		marginTrade:          config.MarginTrade,
+		marginLeverage:       config.MarginLeverage,
+		marginInterestRate:   config.MarginInterestRate,
+		withdrawFee:          make(map[string]float64, len(config.WithdrawFee)),
+		withdrawDelay:        config.WithdrawDelay,
+		queueModel:           config.QueuePositionModel,
+		queueAhead:           make(map[string]float64, 100),
+		orderLatency:         config.OrderLatency,
+		marketDataLatency:    config.MarketDataLatency,
+		unarrived:            make(map[string]bool, 10),
+		cancelling:           make(map[string]bool, 10),
+		cancelErrors:         make(map[string]error, 10),
+		klineVolumeRatio:     config.KlineVolumeRatio,
+		klinePath:            config.KlinePath,
+		pathLimit:            math.Inf(1),
+		clock:                new(marketClock),
+		tracker:              newPositionTracker(config.CostMethod),
+		snapshotInterval:     int64(config.SnapshotInterval / time.Millisecond),
 		klineLoader: loader.NewKLineDataLoader(model.DataConfig{
 			Ex:       config.ExName,
 			StarTime: config.BackTestStartTime,
@@ -68,18 +156,27 @@ func NewExchangeSim(config model.ExchangeSimConfig) *ExchangeSim {
 		backTestDataType: config.BackTestData,
 	}
 
+	if sim.marginLeverage <= 0 {
+		sim.marginLeverage = 3
+	}
+
+	for currency, fee := range config.WithdrawFee {
+		sim.withdrawFee[strings.ToUpper(currency)] = fee
+	}
+
+	if sim.orderLatency == nil && config.OrderLatencyMs > 0 {
+		sim.orderLatency = NewFixedLatency(time.Duration(config.OrderLatencyMs) * time.Millisecond)
+	}
+
 	for _, pair := range config.SupportCurrencyPairs {
-		if !pair.CurrencyB.Eq(config.QuoteCurrency) {
-			panic("the CurrencyPair only one quote currency per backtest")
-		}
-		sim.depthLoader[pair] = loader.NewDepthDataLoader(model.DataConfig{
+		sim.clock.add(sim, pair, loader.NewDepthDataLoader(model.DataConfig{
 			Ex:       sim.name,
 			Pair:     pair,
 			StarTime: config.BackTestStartTime,
 			EndTime:  config.BackTestEndTime,
 			UnGzip:   config.UnGzip,
 			Size:     config.DepthSize,
-		})
+		}))
 	}
 
 	for _, sub := range sim.acc.SubAccounts {
@@ -90,23 +187,20 @@ func NewExchangeSim(config model.ExchangeSimConfig) *ExchangeSim {
 		return strings.Compare(sim.sortedCurrencies[i].Symbol, sim.sortedCurrencies[j].Symbol) > 0
 	})
 
-	var header []string
-	for _, c := range sim.sortedCurrencies {
-		header = append(header, fmt.Sprintf("%s_available", c.Symbol))
-		header = append(header, fmt.Sprintf("%s_frozen", c.Symbol))
-	}
-	header = append(header, "NetAsset")
-
-	csvFile := fmt.Sprintf(AssetSnapshotCsvFileName, sim.name)
-	f, err := os.OpenFile(csvFile, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0744)
-	if err != nil {
-		panic(err)
+	//同一个基础币只记到第一个币对的持仓里
+	sim.tracker.inventory = make(map[string]float64, len(sim.supportCurrencyPairs))
+	seeded := make(map[string]bool, len(sim.supportCurrencyPairs))
+	for _, pair := range sim.supportCurrencyPairs {
+		sub, ok := sim.acc.SubAccounts[pair.CurrencyA]
+		if !ok || sub.Amount <= 0 || seeded[pair.CurrencyA.Symbol] {
+			continue
+		}
+		seeded[pair.CurrencyA.Symbol] = true
+		sim.tracker.inventory[pair.ToSymbol("_")] = sub.Amount
 	}
 
-	csvW := csv.NewWriter(f)
-	csvW.Write(header)
-	csvW.Flush()
-	f.Close()
+	sim.ledger = sim
+	sim.tracker.valuation = sim.valuationPrice
 
 	return sim
 }
@@ -119,25 +213,46 @@ func NewExchangeSimWithTomlConfig(ex string) *ExchangeSim {
 	return NewExchangeSim(c)
 }
 
-func (ex *ExchangeSim) fillOrder(isTaker bool, amount, price float64, ord *goex.Order) {
-	ord.FinishedTime = ex.currDepth.UTime.UnixNano() / int64(time.Millisecond) //set filled time
-	if ex.backTestDataType == model.BackTestDataType_KLine {
-		ord.FinishedTime = ex.currKline.Timestamp
+//K线回测时taker单的成交参考价
+func (ex *ExchangeSim) klineQuote(pair goex.CurrencyPair) float64 {
+	m := ex.market(pair)
+	if m.klineQuotePrice > 0 {
+		return m.klineQuotePrice
 	}
+	return m.kline.Close
+}
 
-	dealAmount := 0.0
+//当前回测时钟的时间戳(毫秒),为最新一个行情事件的时间
+func (ex *ExchangeSim) now() int64 {
+	return ex.currTime
+}
+
+//K线时间戳(毫秒)
+func klineTime(k goex.Kline) int64 {
+	if k.Timestamp < 1e12 { //K线数据的时间戳为秒
+		return k.Timestamp * 1000
+	}
+	return k.Timestamp
+}
+
+//成交,返回实际成交数量
+func (ex *ExchangeSim) fillOrder(isTaker bool, amount, price float64, ord *goex.Order) float64 {
+	ord.FinishedTime = ex.now() //set filled time
+
+	dealAmount := amount
 	remain := ord.Amount - ord.DealAmount
-	if remain > amount {
-		dealAmount = amount
-	} else {
+	if !isMarketBuy(*ord) && remain < amount { //市价买单的Amount是计价币数量,成交数量由撮合时计算
 		dealAmount = remain
 	}
+	if dealAmount <= 0 {
+		return 0
+	}
 
 	ratio := dealAmount / (ord.DealAmount + dealAmount)
-	ord.AvgPrice = math.Round(ratio*price+(1-ratio)*ord.AvgPrice*100000000) / 100000000
+	ord.AvgPrice = math.Round((ratio*price+(1-ratio)*ord.AvgPrice)*100000000) / 100000000
 
 	ord.DealAmount += dealAmount
-	if ord.Amount == ord.DealAmount {
+	if !isMarketBuy(*ord) && ord.Amount == ord.DealAmount {
 		ord.Status = goex.ORDER_FINISH
 	} else {
 		if ord.DealAmount > 0 {
@@ -150,18 +265,21 @@ func (ex *ExchangeSim) fillOrder(isTaker bool, amount, price float64, ord *goex.
 		fee = ex.takerFee
 	}
 
-	tradeFee := 0.0
-	switch ord.Side {
-	case goex.SELL:
-		tradeFee = dealAmount * price * fee
-	case goex.BUY:
-		tradeFee = dealAmount * fee
-	}
+	tradeFee := ex.ledger.tradeFee(dealAmount, price, fee, *ord)
 	tradeFee = math.Floor(tradeFee*100000000) / 100000000
 
 	ord.Fee += tradeFee
 
-	ex.unFrozenAsset(tradeFee, dealAmount, price, *ord)
+	ex.ledger.unFrozenAsset(tradeFee, dealAmount, price, *ord)
+	ex.recordFill(ord, price, dealAmount, tradeFee, isTaker)
+
+	eventType := OrderEventType_PartialFill
+	if ord.Status == goex.ORDER_FINISH {
+		eventType = OrderEventType_Filled
+	}
+	ex.emitFillEvent(eventType, ord, price, dealAmount, tradeFee, isTaker)
+
+	return dealAmount
 }
 
 func (ex *ExchangeSim) matchOrder(ord *goex.Order, isTaker bool) {
@@ -174,52 +292,284 @@ func (ex *ExchangeSim) matchOrder(ord *goex.Order, isTaker bool) {
 }
 
 func (ex *ExchangeSim) matchOrderByDepthData(ord *goex.Order, isTaker bool) {
+	m := ex.market(ord.Currency)
 	switch ord.Side {
 	case goex.SELL:
-		for idx := 0; idx < len(ex.currDepth.BidList); idx++ {
-			bid := ex.currDepth.BidList[idx]
-			if bid.Price >= ord.Price && bid.Amount > 0 {
-				ex.fillOrder(isTaker, bid.Amount, bid.Price, ord)
-				if ord.Status == goex.ORDER_FINISH {
-					delete(ex.pendingOrders, ord.OrderID2)
-					ex.finishedOrders[ord.OrderID2] = ord
-					break
-				}
-			} else {
+		for idx := 0; idx < len(m.depth.BidList); idx++ {
+			bid := m.depth.BidList[idx]
+			if bid.Price < ord.Price {
+				break
+			}
+			available := m.liquidity.available(ord.Side, bid)
+			if available <= 0 {
+				continue
+			}
+			price := bid.Price
+			if isTaker {
+				price = ex.takerPrice(ord, math.Min(available, ord.Amount-ord.DealAmount), price)
+			}
+			dealAmount := ex.fillOrder(isTaker, available, price, ord)
+			m.liquidity.consume(ord.Side, bid.Price, dealAmount)
+			if ord.Status == goex.ORDER_FINISH {
+				ex.finishOrder(ord)
 				break
 			}
 		}
 	case goex.BUY:
-		idx := len(ex.currDepth.AskList) - 1
+		idx := len(m.depth.AskList) - 1
 		for ; idx >= 0; idx-- {
-			ask := ex.currDepth.AskList[idx]
-			if ask.Price <= ord.Price && ask.Amount > 0 {
-				ex.fillOrder(isTaker, ask.Amount, ask.Price, ord)
-				if ord.Status == goex.ORDER_FINISH {
-					delete(ex.pendingOrders, ord.OrderID2)
-					ex.finishedOrders[ord.OrderID2] = ord
-					break
-				}
-			} else {
+			ask := m.depth.AskList[idx]
+			if ask.Price > ord.Price {
+				break
+			}
+			available := m.liquidity.available(ord.Side, ask)
+			if available <= 0 {
+				continue
+			}
+			price := ask.Price
+			if isTaker {
+				price = ex.takerPrice(ord, math.Min(available, ord.Amount-ord.DealAmount), price)
+			}
+			dealAmount := ex.fillOrder(isTaker, available, price, ord)
+			m.liquidity.consume(ord.Side, ask.Price, dealAmount)
+			if ord.Status == goex.ORDER_FINISH {
+				ex.finishOrder(ord)
 				break
 			}
 		}
 	}
 }
 
-func (ex *ExchangeSim) matchOrderByKlineData(ord *goex.Order, isTaker bool) {
-	ex.fillOrder(isTaker, ord.Amount, ord.Price, ord)
+//taker成交价格按滑点模型调整,限价单不会成交在比委托价更差的价格
+func (ex *ExchangeSim) takerPrice(ord *goex.Order, amount, price float64) float64 {
+	if ex.slippage == nil || amount <= 0 {
+		return price
+	}
+
+	m := ex.market(ord.Currency)
+	volume := m.kline.Vol
+	if ex.backTestDataType == model.BackTestDataType_Depth {
+		volume = 0
+		levels := m.depth.AskList
+		if ord.Side == goex.SELL {
+			levels = m.depth.BidList
+		}
+		for _, level := range levels {
+			volume += level.Amount
+		}
+	}
+
+	slipped := ex.slippage.Price(model.SlippageContext{
+		Pair:       ord.Currency,
+		Side:       ord.Side,
+		Price:      price,
+		Amount:     amount,
+		Volume:     volume,
+		Volatility: m.volatility.value(),
+	})
+
+	if execType(*ord) == "limit" {
+		if ord.Side == goex.BUY {
+			slipped = math.Min(slipped, ord.Price)
+		} else {
+			slipped = math.Max(slipped, ord.Price)
+		}
+	}
+
+	return slipped
+}
+
+//市价单按深度逐档成交,吃完深度后剩余部分撤销
+func (ex *ExchangeSim) matchMarketOrder(ord *goex.Order) {
+	remain := ord.Amount //买单为剩余的计价币数量,卖单为剩余的基础币数量
+	m := ex.market(ord.Currency)
+
+	switch ex.backTestDataType {
+	case model.BackTestDataType_Depth:
+		switch ord.Side {
+		case goex.BUY:
+			for idx := len(m.depth.AskList) - 1; idx >= 0 && remain > 0; idx-- {
+				ask := m.depth.AskList[idx]
+				available := m.liquidity.available(ord.Side, ask)
+				if ask.Price <= 0 || available <= 0 {
+					continue
+				}
+				price := ex.takerPrice(ord, math.Min(available, remain/ask.Price), ask.Price)
+				dealAmount := ex.fillOrder(true, ex.floorToStep(ord.Currency, math.Min(available, remain/price)), price, ord)
+				m.liquidity.consume(ord.Side, ask.Price, dealAmount)
+				remain -= dealAmount * price
+			}
+		case goex.SELL:
+			for idx := 0; idx < len(m.depth.BidList) && remain > 0; idx++ {
+				bid := m.depth.BidList[idx]
+				available := m.liquidity.available(ord.Side, bid)
+				if bid.Price <= 0 || available <= 0 {
+					continue
+				}
+				price := ex.takerPrice(ord, math.Min(available, remain), bid.Price)
+				dealAmount := ex.fillOrder(true, math.Min(available, remain), price, ord)
+				m.liquidity.consume(ord.Side, bid.Price, dealAmount)
+				remain -= dealAmount
+			}
+		}
+	case model.BackTestDataType_KLine:
+		switch ord.Side {
+		case goex.BUY:
+			price := ex.klineMarketPrice(ord)
+			price = ex.takerPrice(ord, math.Min(remain/price, m.klineVolumeAvailable(ex.klineVolumeRatio)), price)
+			dealAmount := ex.fillOrder(true, ex.floorToStep(ord.Currency, math.Min(remain/price, m.klineVolumeAvailable(ex.klineVolumeRatio))), price, ord)
+			m.klineConsumedVol += dealAmount
+			remain -= dealAmount * price
+		case goex.SELL:
+			price := ex.klineMarketPrice(ord)
+			price = ex.takerPrice(ord, math.Min(remain, m.klineVolumeAvailable(ex.klineVolumeRatio)), price)
+			dealAmount := ex.fillOrder(true, math.Min(remain, m.klineVolumeAvailable(ex.klineVolumeRatio)), price, ord)
+			m.klineConsumedVol += dealAmount
+			remain -= dealAmount
+		}
+	}
+
+	ex.finishMarketOrder(ord, remain)
+}
+
+//K线回测市价单的成交参考价,配置了滑点模型时滑点由takerPrice计算,否则按MarketOrderSlippage
+func (ex *ExchangeSim) klineMarketPrice(ord *goex.Order) float64 {
+	price := ex.klineQuote(ord.Currency)
+	if ex.slippage != nil {
+		return price
+	}
+	if ord.Side == goex.BUY {
+		return price * (1 + ex.marketOrderSlippage)
+	}
+	return price * (1 - ex.marketOrderSlippage)
+}
+
+func (ex *ExchangeSim) finishMarketOrder(ord *goex.Order, remain float64) {
+	ex.finishOrder(ord)
+
+	filled := remain <= ord.Amount*1e-8 //浮点误差
+	if isMarketBuy(*ord) && ord.DealAmount > 0 && ex.floorToStep(ord.Currency, remain/ord.AvgPrice) == 0 {
+		filled = true //剩余金额不够买一个数量精度
+	}
+	if filled {
+		if ord.Status != goex.ORDER_FINISH { //市价卖单全部成交时fillOrder已推送filled
+			ord.Status = goex.ORDER_FINISH
+			ex.emitOrderEvent(OrderEventType_Filled, ord)
+		}
+	} else {
+		ord.Status = goex.ORDER_CANCEL
+		ord.FinishedTime = ex.now()
+		ex.emitOrderEvent(OrderEventType_Cancelled, ord)
+	}
+
+	if remain == 0 {
+		return
+	}
+
+	currency := ord.Currency.CurrencyA
+	if ord.Side == goex.BUY {
+		currency = ord.Currency.CurrencyB
+	}
+	sub := ex.acc.SubAccounts[currency]
+	sub.Amount += remain
+	sub.ForzenAmount -= remain
+	ex.acc.SubAccounts[currency] = sub
+	ex.autoRepay(currency)
 }
 
 func (ex *ExchangeSim) match() {
 	ex.Lock()
 	defer ex.Unlock()
-	for id, _ := range ex.pendingOrders {
-		ex.matchOrder(ex.pendingOrders[id], false)
+	ex.matchTick()
+}
+
+//行情更新后按各自币对的行情撮合所有挂单,然后检查条件单是否触发
+func (ex *ExchangeSim) matchTick() {
+	ex.matchPath()
+	ex.ledger.afterMatch()
+	ex.recordEquity()
+	ex.autoSnapshot()
+}
+
+//K线回测设置了pathCheck时沿价格路径逐点撮合已触及的挂单和条件单,每个点撮合后检查,
+//检查返回true时路径上之后才触及的订单不再撮合
+func (ex *ExchangeSim) matchPath() {
+	if ex.backTestDataType != model.BackTestDataType_KLine || ex.pathCheck == nil {
+		ex.matchPendingOrders()
+		ex.matchTriggerOrders()
+		return
+	}
+
+	defer func() { ex.pathLimit = math.Inf(1) }()
+	for point := 0; point < klinePathPoints; point++ {
+		ex.pathLimit = float64(point)
+		ex.matchPendingOrders()
+		ex.matchTriggerOrders()
+		if ex.pathCheck(point) {
+			return
+		}
+	}
+}
+
+//按回测时间每隔snapshotInterval记录一次净值快照,撮合引擎不创建快照文件
+func (ex *ExchangeSim) autoSnapshot() {
+	if ex.snapshotInterval <= 0 || ex.currTime < ex.nextSnapshotTime {
+		return
+	}
+	ex.ledger.assetSnapshot()
+	ex.nextSnapshotTime = (ex.currTime/ex.snapshotInterval + 1) * ex.snapshotInterval
+}
+
+func (ex *ExchangeSim) matchPendingOrders() {
+	for _, ord := range ex.sortedPendingOrders() {
+		if ex.unarrived[ord.OrderID2] {
+			continue
+		}
+		if !ex.updateQueuePosition(ord) {
+			continue
+		}
+		ex.matchOrder(ord, false)
 	}
 }
 
+//按下单先后排序,同一快照内先挂的单先吃到流动性,保证回测结果可复现
+func (ex *ExchangeSim) sortedPendingOrders() []*goex.Order {
+	orders := make([]*goex.Order, 0, len(ex.pendingOrders))
+	for _, ord := range ex.pendingOrders {
+		orders = append(orders, ord)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].OrderTime != orders[j].OrderTime {
+			return orders[i].OrderTime < orders[j].OrderTime
+		}
+		if len(orders[i].OrderID2) != len(orders[j].OrderID2) {
+			return len(orders[i].OrderID2) < len(orders[j].OrderID2)
+		}
+		return orders[i].OrderID2 < orders[j].OrderID2
+	})
+
+	if ex.backTestDataType == model.BackTestDataType_KLine {
+		//K线内按价格路径先触及的挂单先成交
+		sort.SliceStable(orders, func(i, j int) bool {
+			return ex.touchPosition(*orders[i]) < ex.touchPosition(*orders[j])
+		})
+	}
+
+	return orders
+}
+
 func (ex *ExchangeSim) LimitBuy(amount, price string, currency goex.CurrencyPair, opt ...goex.LimitOrderOptionalParameter) (*goex.Order, error) {
+	return ex.limitOrder(goex.BUY, amount, price, currency, opt...)
+}
+
+func (ex *ExchangeSim) LimitSell(amount, price string, currency goex.CurrencyPair, opt ...goex.LimitOrderOptionalParameter) (*goex.Order, error) {
+	return ex.limitOrder(goex.SELL, amount, price, currency, opt...)
+}
+
+//限价单,支持PostOnly、IOC、FOK:
+//PostOnly会立即成交时拒绝(ORDER_REJECT);IOC立即撮合后撤销剩余部分;FOK不能全部成交时直接撤销
+func (ex *ExchangeSim) limitOrder(side goex.TradeSide, amount, price string, currency goex.CurrencyPair, opt ...goex.LimitOrderOptionalParameter) (*goex.Order, error) {
+	defer ex.flushOrderEvents()
 	ex.Lock()
 	defer ex.Unlock()
 
@@ -227,99 +577,226 @@ func (ex *ExchangeSim) LimitBuy(amount, price string, currency goex.CurrencyPair
 		Price:     goex.ToFloat64(price),
 		Amount:    goex.ToFloat64(amount),
 		OrderID2:  ex.idGen.Get(),
-		OrderTime: int(ex.currDepth.UTime.UnixNano() / int64(time.Millisecond)),
+		OrderTime: int(ex.now()),
 		Status:    goex.ORDER_UNFINISH,
 		Currency:  currency,
-		Side:      goex.BUY,
+		Side:      side,
 		Type:      "limit",
+		OrderType: orderFeature(opt...),
 	}
 	//ord.Cid = ord.OrderID2
 
-	if ex.backTestDataType == model.BackTestDataType_KLine {
-		ord.OrderTime = int(ex.currKline.Timestamp)
+	return ex.placeOrder(ord)
+}
+
+//检查交易规则并冻结资产后下单
+func (ex *ExchangeSim) placeOrder(ord goex.Order) (*goex.Order, error) {
+	err := ex.checkTradingRule(ord)
+	if err != nil {
+		return nil, err
 	}
 
-	err := ex.frozenAsset(ord)
+	err = ex.ledger.frozenAsset(ord)
 	if err != nil {
 		return nil, err
 	}
 
 	ex.pendingOrders[ord.OrderID2] = &ord
+	ex.emitOrderEvent(OrderEventType_New, &ord)
 
-	ex.matchOrder(&ord, true)
+	if !ex.delayRequest(&ord, false) {
+		ex.submitOrder(&ord)
+	}
 
 	var result goex.Order
 	util.DeepCopyStruct(ord, &result)
+
 	return &result, nil
 }
 
-func (ex *ExchangeSim) LimitSell(amount, price string, currency goex.CurrencyPair, opt ...goex.LimitOrderOptionalParameter) (*goex.Order, error) {
-	ex.Lock()
-	defer ex.Unlock()
+//订单到达撮合引擎
+func (ex *ExchangeSim) submitOrder(ord *goex.Order) {
+	if execType(*ord) == "market" {
+		ex.matchMarketOrder(ord)
+		return
+	}
 
-	ord := goex.Order{
-		Price:     goex.ToFloat64(price),
-		Amount:    goex.ToFloat64(amount),
-		OrderID2:  ex.idGen.Get(),
-		OrderTime: int(ex.currDepth.UTime.UnixNano() / int64(time.Millisecond)),
-		Status:    goex.ORDER_UNFINISH,
-		Currency:  currency,
-		Side:      goex.SELL,
-		Type:      "limit",
+	switch ord.OrderType {
+	case goex.ORDER_FEATURE_POST_ONLY:
+		if ex.fillableAmount(*ord) > 0 {
+			ex.closeOrder(ord, goex.ORDER_REJECT)
+		}
+	case goex.ORDER_FEATURE_FOK:
+		if ex.fillableAmount(*ord) < ord.Amount {
+			ex.closeOrder(ord, goex.ORDER_CANCEL)
+		} else {
+			ex.matchOrder(ord, true)
+		}
+	case goex.ORDER_FEATURE_IOC:
+		ex.matchOrder(ord, true)
+		if ord.Status != goex.ORDER_FINISH {
+			ex.closeOrder(ord, goex.ORDER_CANCEL)
+		}
+	default:
+		ex.matchOrder(ord, true)
 	}
 
-	if ex.backTestDataType == model.BackTestDataType_KLine {
-		ord.OrderTime = int(ex.currKline.Timestamp)
+	if ex.pendingOrders[ord.OrderID2] != nil {
+		ex.joinQueue(ord)
 	}
-	//ord.Cid = ord.OrderID2
+}
 
-	err := ex.frozenAsset(ord)
-	if err != nil {
-		return nil, err
+func orderFeature(opt ...goex.LimitOrderOptionalParameter) int {
+	if len(opt) == 0 {
+		return goex.ORDER_FEATURE_ORDINARY
+	}
+	switch opt[0] {
+	case goex.PostOnly:
+		return goex.ORDER_FEATURE_POST_ONLY
+	case goex.Ioc:
+		return goex.ORDER_FEATURE_IOC
+	case goex.Fok:
+		return goex.ORDER_FEATURE_FOK
+	default:
+		return goex.ORDER_FEATURE_ORDINARY
 	}
+}
 
-	ex.pendingOrders[ord.OrderID2] = &ord
+//以taker身份在当前行情下立即可成交的数量
+func (ex *ExchangeSim) fillableAmount(ord goex.Order) float64 {
+	fillable := 0.0
+	m := ex.market(ord.Currency)
+	switch ex.backTestDataType {
+	case model.BackTestDataType_Depth:
+		switch ord.Side {
+		case goex.SELL:
+			for _, bid := range m.depth.BidList {
+				if bid.Price < ord.Price {
+					break
+				}
+				fillable += m.liquidity.available(ord.Side, bid)
+			}
+		case goex.BUY:
+			for idx := len(m.depth.AskList) - 1; idx >= 0; idx-- {
+				ask := m.depth.AskList[idx]
+				if ask.Price > ord.Price {
+					break
+				}
+				fillable += m.liquidity.available(ord.Side, ask)
+			}
+		}
+	case model.BackTestDataType_KLine:
+		if marketable(ord, ex.klineQuote(ord.Currency)) {
+			fillable = math.Min(ord.Amount, m.klineVolumeAvailable(ex.klineVolumeRatio))
+		}
+	}
+	return fillable
+}
 
-	ex.matchOrder(&ord, true)
+//订单从挂单列表移到历史订单
+func (ex *ExchangeSim) finishOrder(ord *goex.Order) {
+	delete(ex.pendingOrders, ord.OrderID2)
+	delete(ex.queueAhead, ord.OrderID2)
+	ex.finishedOrders[ord.OrderID2] = ord
+}
 
-	var result goex.Order
-	util.DeepCopyStruct(ord, &result)
+//结束挂单(撤单或拒绝),解冻剩余资产
+func (ex *ExchangeSim) closeOrder(ord *goex.Order, status goex.TradeStatus) {
+	ord.Status = status
+	ord.FinishedTime = ex.now()
+	ex.finishOrder(ord)
 
-	return &result, nil
+	ex.ledger.unFrozenAsset(0, 0, 0, *ord)
+
+	if status == goex.ORDER_REJECT {
+		ex.emitOrderEvent(OrderEventType_Rejected, ord)
+	} else {
+		ex.emitOrderEvent(OrderEventType_Cancelled, ord)
+	}
 }
 
+//撤单,未触发的条件单没有冻结资产,直接移到历史订单
+func (ex *ExchangeSim) cancelOrder(ord *goex.Order) {
+	if ex.triggerOrders[ord.OrderID2] != nil {
+		delete(ex.triggerOrders, ord.OrderID2)
+		ord.Status = goex.ORDER_CANCEL
+		ord.FinishedTime = ex.now()
+		ex.finishOrder(ord)
+		ex.emitOrderEvent(OrderEventType_Cancelled, ord)
+		return
+	}
+
+	if ex.pendingOrders[ord.OrderID2] != nil {
+		ex.closeOrder(ord, goex.ORDER_CANCEL)
+	}
+}
+
+//市价买单,amount为计价币数量(与火币、币安一致),price参数忽略
 func (ex *ExchangeSim) MarketBuy(amount, price string, currency goex.CurrencyPair) (*goex.Order, error) {
-	panic("not support")
+	return ex.marketOrder(goex.BUY, amount, currency)
 }
 
+//市价卖单,amount为基础币数量,price参数忽略
 func (ex *ExchangeSim) MarketSell(amount, price string, currency goex.CurrencyPair) (*goex.Order, error) {
-	panic("not support")
+	return ex.marketOrder(goex.SELL, amount, currency)
+}
+
+func (ex *ExchangeSim) marketOrder(side goex.TradeSide, amount string, currency goex.CurrencyPair) (*goex.Order, error) {
+	defer ex.flushOrderEvents()
+	ex.Lock()
+	defer ex.Unlock()
+
+	ord := goex.Order{
+		Amount:    goex.ToFloat64(amount),
+		OrderID2:  ex.idGen.Get(),
+		OrderTime: int(ex.now()),
+		Status:    goex.ORDER_UNFINISH,
+		Currency:  currency,
+		Side:      side,
+		Type:      "market",
+	}
+
+	//K线回测在第一根K线之前没有成交参考价
+	if ex.backTestDataType == model.BackTestDataType_KLine && ex.klineQuote(currency) <= 0 {
+		return nil, NoMarketPriceError
+	}
+
+	return ex.placeOrder(ord)
 }
 
 func (ex *ExchangeSim) CancelOrder(orderId string, currency goex.CurrencyPair) (bool, error) {
+	defer ex.flushOrderEvents()
 	ex.Lock()
 	defer ex.Unlock()
 
+	if ex.cancelling[orderId] { //撤单请求已经发出,结果要等撤单到达撮合引擎
+		return true, nil
+	}
+
 	ord := ex.finishedOrders[orderId]
 	if ord != nil {
 		return false, CancelOrderFinishedError
 	}
 
 	ord = ex.pendingOrders[orderId]
+	if ord == nil && ex.triggerOrders[orderId] != nil {
+		ord = ex.triggerOrders[orderId].ord
+	}
 	if ord == nil {
 		return false, NotFoundOrderError
 	}
 
-	delete(ex.pendingOrders, ord.OrderID2)
-
-	ord.Status = goex.ORDER_CANCEL
-	ex.finishedOrders[ord.OrderID2] = ord
+	if ex.delayRequest(ord, true) {
+		ex.cancelling[orderId] = true
+		return true, nil
+	}
 
-	ex.unFrozenAsset(0, 0, 0, *ord)
+	ex.cancelOrder(ord)
 
 	return true, nil
 }
 
+//延迟撤单到达时订单已经成交完成的,返回最终状态的订单和CancelOrderFinishedError
 func (ex *ExchangeSim) GetOneOrder(orderId string, currency goex.CurrencyPair) (*goex.Order, error) {
 	ex.RLock()
 	defer ex.RUnlock()
@@ -328,13 +805,17 @@ func (ex *ExchangeSim) GetOneOrder(orderId string, currency goex.CurrencyPair) (
 	if ord == nil {
 		ord = ex.pendingOrders[orderId]
 	}
+	if ord == nil && ex.triggerOrders[orderId] != nil {
+		ord = ex.triggerOrders[orderId].ord
+	}
 
 	if ord != nil {
 		// deep copy
 		var result goex.Order
 		util.DeepCopyStruct(ord, &result)
+		result.Status = ex.orderStatus(ord)
 
-		return &result, nil
+		return &result, ex.cancelErrors[orderId]
 	}
 
 	return nil, NotFoundOrderError
@@ -346,7 +827,10 @@ func (ex *ExchangeSim) GetUnfinishOrders(currency goex.CurrencyPair) ([]goex.Ord
 
 	var unfinishedOrders []goex.Order
 	for _, ord := range ex.pendingOrders {
-		unfinishedOrders = append(unfinishedOrders, *ord)
+		unfinishedOrders = append(unfinishedOrders, ex.orderView(ord))
+	}
+	for _, t := range ex.triggerOrders {
+		unfinishedOrders = append(unfinishedOrders, ex.orderView(t.ord))
 	}
 
 	return unfinishedOrders, nil
@@ -359,12 +843,26 @@ func (ex *ExchangeSim) GetOrderHistorys(currency goex.CurrencyPair, opt ...goex.
 	var orders []goex.Order
 	for _, ord := range ex.finishedOrders {
 		if ord.Currency.Eq(currency) {
-			orders = append(orders, *ord)
+			orders = append(orders, ex.orderView(ord))
 		}
 	}
 	return orders, nil
 }
 
+//对外显示的订单状态,撤单请求到达前订单一直是撤单中,之后才显示成交或撤销的结果
+func (ex *ExchangeSim) orderStatus(ord *goex.Order) goex.TradeStatus {
+	if ex.cancelling[ord.OrderID2] {
+		return goex.ORDER_CANCEL_ING
+	}
+	return ord.Status
+}
+
+func (ex *ExchangeSim) orderView(ord *goex.Order) goex.Order {
+	view := *ord
+	view.Status = ex.orderStatus(ord)
+	return view
+}
+
 func (ex *ExchangeSim) GetAccount() (*goex.Account, error) {
 	ex.RLock()
 	defer ex.RUnlock()
@@ -376,6 +874,7 @@ func (ex *ExchangeSim) GetAccount() (*goex.Account, error) {
 			Currency:     sub.Currency,
 			Amount:       sub.Amount,
 			ForzenAmount: sub.ForzenAmount,
+			LoanAmount:   sub.LoanAmount,
 		}
 	}
 
@@ -383,33 +882,86 @@ func (ex *ExchangeSim) GetAccount() (*goex.Account, error) {
 }
 
 func (ex *ExchangeSim) GetTicker(currency goex.CurrencyPair) (*goex.Ticker, error) {
-	ask := ex.currDepth.AskList[len(ex.currDepth.AskList)-1].Price
-	bid := ex.currDepth.BidList[0].Price
+	ex.RLock()
+	defer ex.RUnlock()
+
+	depth := ex.market(currency).depth
+	if len(depth.AskList) == 0 || len(depth.BidList) == 0 {
+		return nil, NoMarketPriceError
+	}
+	ask := depth.AskList[len(depth.AskList)-1].Price
+	bid := depth.BidList[0].Price
 	return &goex.Ticker{
 		Pair: currency,
 		Last: (ask + bid) / 2,
 		Sell: ask,
 		Buy:  bid,
+		Date: uint64(depth.UTime.UnixNano() / int64(time.Millisecond)),
 	}, nil
 }
 
+//推进行情时钟到该币对的下一个深度快照,其它币对时间更早的快照先按时间顺序撮合
 func (ex *ExchangeSim) GetDepth(size int, currency goex.CurrencyPair) (*goex.Depth, error) {
-	depth := ex.depthLoader[currency].Next()
+	depth := ex.clock.advance(ex, currency)
 	if depth == nil {
 		return nil, DataFinishedError
 	}
-	ex.currDepth = *depth
-	ex.match()
 	return depth, nil
 }
 
+//新的K线到来,重新计算可成交的成交量
+func (ex *ExchangeSim) updateKline(kline goex.Kline) {
+	defer ex.flushOrderEvents()
+	ex.Lock()
+	defer ex.Unlock()
+	m := ex.market(kline.Pair)
+	m.prevKline = m.kline
+	m.kline = kline
+	m.klineConsumedVol = 0
+	m.volatility.add(kline.Close)
+	ex.lastPrices[kline.Pair.ToSymbol("_")] = kline.Close
+	ex.tracker.onPrice(kline.Pair, kline.High, kline.Low)
+	ex.currTime = klineTime(kline)
+	ex.tracker.seed(kline.Pair, kline.Close, ex.currTime)
+
+	//延迟到达的订单在这根K线开盘时进入撮合,之后策略新下的单按收盘价撮合
+	m.klineQuotePrice = kline.Open
+	ex.processArrivedRequests()
+	m.klineQuotePrice = 0
+	ex.matchTick()
+}
+
+//新的深度快照到来,之前模拟成交消耗的流动性作废
+func (ex *ExchangeSim) updateDepth(depth goex.Depth) {
+	defer ex.flushOrderEvents()
+	ex.applyDepth(depth)
+}
+
+//撮合新的深度快照,不推送订单事件
+func (ex *ExchangeSim) applyDepth(depth goex.Depth) {
+	ex.Lock()
+	defer ex.Unlock()
+	m := ex.market(depth.Pair)
+	m.depth = depth
+	m.liquidity.reset()
+	if len(depth.AskList) > 0 && len(depth.BidList) > 0 {
+		ex.lastPrices[depth.Pair.ToSymbol("_")] = depth.BidList[0].Price //与之前一样按买一价估值
+		mid := (depth.AskList[len(depth.AskList)-1].Price + depth.BidList[0].Price) / 2
+		m.volatility.add(mid)
+		ex.tracker.onPrice(depth.Pair, mid, mid)
+	}
+	ex.currTime = depth.UTime.UnixNano() / int64(time.Millisecond)
+	ex.tracker.seed(depth.Pair, ex.lastPrices[depth.Pair.ToSymbol("_")], ex.currTime)
+	ex.processArrivedRequests()
+	ex.matchTick()
+}
+
 func (ex *ExchangeSim) GetKlineRecords(currency goex.CurrencyPair, period goex.KlinePeriod, size int, opt ...goex.OptionalParameter) ([]goex.Kline, error) {
 	data, err := ex.klineLoader.Next(currency, period, size)
 	if err != nil {
 		return nil, err
 	}
-	ex.currKline = data[0]
-	ex.match()
+	ex.updateKline(data[0])
 	return data, err
 }
 
@@ -417,15 +969,67 @@ func (ex *ExchangeSim) GetTrades(currencyPair goex.CurrencyPair, since int64) ([
 	panic("not support")
 }
 
+//推进行情时钟到下一个深度快照(任意币对),数据结束时返回DataFinishedError
+func (ex *ExchangeSim) NextDepth() (*goex.Depth, error) {
+	_, depth := ex.clock.next()
+	if depth == nil {
+		return nil, DataFinishedError
+	}
+	return depth, nil
+}
+
 func (ex *ExchangeSim) GetExchangeName() string {
 	return ex.name
 }
 
+func (ex *ExchangeSim) BackTestDataType() model.BackTestDataType {
+	return ex.backTestDataType
+}
+
+//资产记账,撮合引擎下单、成交、撤单时调用
+type assetLedger interface {
+	frozenAsset(order goex.Order) error
+	unFrozenAsset(fee, matchAmount, matchPrice float64, order goex.Order)
+	tradeFee(matchAmount, matchPrice, feeRate float64, order goex.Order) float64
+	feeCurrency(order goex.Order) goex.Currency
+	openType(order goex.Order) int
+	afterMatch() //每次行情更新撮合完成后调用,用于结算资金费率等
+	netAsset() float64
+	assetSnapshot() //写一行净值快照,调用方持有锁
+}
+
+//现货手续费,买单扣基础币,卖单扣计价币
+func (ex *ExchangeSim) tradeFee(matchAmount, matchPrice, feeRate float64, order goex.Order) float64 {
+	if order.Side == goex.SELL {
+		return matchAmount * matchPrice * feeRate
+	}
+	return matchAmount * feeRate
+}
+
+func (ex *ExchangeSim) feeCurrency(order goex.Order) goex.Currency {
+	if order.Side == goex.SELL {
+		return order.Currency.CurrencyB
+	}
+	return order.Currency.CurrencyA
+}
+
+//合约订单的开平仓类型,现货为0
+func (ex *ExchangeSim) openType(order goex.Order) int {
+	return 0
+}
+
+func (ex *ExchangeSim) afterMatch() {
+	ex.accrueInterest()
+}
+
 //冻结
 func (ex *ExchangeSim) frozenAsset(order goex.Order) error {
 
 	switch order.Side {
 	case goex.SELL:
+		if err := ex.autoBorrow(order.Currency.CurrencyA, order.Amount); err != nil {
+			return err
+		}
 		avaAmount := ex.acc.SubAccounts[order.Currency.CurrencyA].Amount
 		if avaAmount < order.Amount {
 			return InsufficientError
@@ -434,11 +1038,17 @@ func (ex *ExchangeSim) frozenAsset(order goex.Order) error {
 			Currency:     order.Currency.CurrencyA,
 			Amount:       avaAmount - order.Amount,
 			ForzenAmount: ex.acc.SubAccounts[order.Currency.CurrencyA].ForzenAmount + order.Amount,
-			LoanAmount:   0,
+			LoanAmount:   ex.acc.SubAccounts[order.Currency.CurrencyA].LoanAmount,
 		}
 	case goex.BUY:
-		avaAmount := ex.acc.SubAccounts[order.Currency.CurrencyB].Amount
 		need := order.Amount * order.Price
+		if isMarketBuy(order) {
+			need = order.Amount
+		}
+		if err := ex.autoBorrow(order.Currency.CurrencyB, need); err != nil {
+			return err
+		}
+		avaAmount := ex.acc.SubAccounts[order.Currency.CurrencyB].Amount
 		if avaAmount < need {
 			return InsufficientError
 		}
@@ -446,7 +1056,7 @@ func (ex *ExchangeSim) frozenAsset(order goex.Order) error {
 			Currency:     order.Currency.CurrencyB,
 			Amount:       avaAmount - need,
 			ForzenAmount: ex.acc.SubAccounts[order.Currency.CurrencyB].ForzenAmount + need,
-			LoanAmount:   0,
+			LoanAmount:   ex.acc.SubAccounts[order.Currency.CurrencyB].LoanAmount,
 		}
 	}
 
@@ -460,52 +1070,73 @@ func (ex *ExchangeSim) unFrozenAsset(fee, matchAmount, matchPrice float64, order
 
 	switch order.Side {
 	case goex.SELL:
-		if order.Status == goex.ORDER_CANCEL {
+		if order.Status == goex.ORDER_CANCEL || order.Status == goex.ORDER_REJECT {
 			ex.acc.SubAccounts[assetA.Currency] = goex.SubAccount{
 				Currency:     assetA.Currency,
 				Amount:       assetA.Amount + order.Amount - order.DealAmount,
 				ForzenAmount: assetA.ForzenAmount - (order.Amount - order.DealAmount),
-				LoanAmount:   0,
+				LoanAmount:   assetA.LoanAmount,
 			}
 		} else {
 			ex.acc.SubAccounts[assetA.Currency] = goex.SubAccount{
 				Currency:     assetA.Currency,
 				Amount:       assetA.Amount,
 				ForzenAmount: assetA.ForzenAmount - matchAmount,
-				LoanAmount:   0,
+				LoanAmount:   assetA.LoanAmount,
 			}
 			ex.acc.SubAccounts[assetB.Currency] = goex.SubAccount{
 				Currency:     assetB.Currency,
 				Amount:       assetB.Amount + matchAmount*matchPrice - fee,
 				ForzenAmount: assetB.ForzenAmount,
+				LoanAmount:   assetB.LoanAmount,
 			}
 		}
 
 	case goex.BUY:
-		if order.Status == goex.ORDER_CANCEL {
+		if order.Status == goex.ORDER_CANCEL || order.Status == goex.ORDER_REJECT {
 			unFrozen := (order.Amount - order.DealAmount) * order.Price
 			ex.acc.SubAccounts[assetB.Currency] = goex.SubAccount{
 				Currency:     assetB.Currency,
 				Amount:       assetB.Amount + unFrozen,
 				ForzenAmount: assetB.ForzenAmount - unFrozen,
+				LoanAmount:   assetB.LoanAmount,
 			}
 		} else {
+			frozenPrice := order.Price
+			if isMarketBuy(order) {
+				frozenPrice = matchPrice //市价买单冻结的是计价币数量,按成交额解冻
+			}
 			ex.acc.SubAccounts[assetA.Currency] = goex.SubAccount{
 				Currency:     assetA.Currency,
 				Amount:       assetA.Amount + matchAmount - fee,
 				ForzenAmount: assetA.ForzenAmount,
-				LoanAmount:   0,
+				LoanAmount:   assetA.LoanAmount,
 			}
 			ex.acc.SubAccounts[assetB.Currency] = goex.SubAccount{
 				Currency:     assetB.Currency,
-				Amount:       assetB.Amount + matchAmount*(order.Price-matchPrice),
-				ForzenAmount: assetB.ForzenAmount - matchAmount*order.Price,
+				Amount:       assetB.Amount + matchAmount*(frozenPrice-matchPrice),
+				ForzenAmount: assetB.ForzenAmount - matchAmount*frozenPrice,
+				LoanAmount:   assetB.LoanAmount,
 			}
 		}
 	}
+
+	ex.autoRepay(assetA.Currency)
+	ex.autoRepay(assetB.Currency)
 }
 
+func isMarketBuy(ord goex.Order) bool {
+	return execType(ord) == "market" && ord.Side == goex.BUY
+}
+
+//记录当前回测时间的资产和净值
 func (ex *ExchangeSim) AssetSnapshot() {
+	ex.RLock()
+	defer ex.RUnlock()
+	ex.assetSnapshot()
+}
+
+func (ex *ExchangeSim) assetSnapshot() {
 	csvFile := fmt.Sprintf(AssetSnapshotCsvFileName, ex.name)
 	f, err := os.OpenFile(csvFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0744)
 	if err != nil {
@@ -521,33 +1152,43 @@ func (ex *ExchangeSim) AssetSnapshot() {
 
 	csvW := csv.NewWriter(f)
 
-	var (
-		data []string
-	)
+	data := []string{fmt.Sprint(ex.now())}
 
-	netAsset := 0.0
 	for _, currency := range ex.sortedCurrencies {
 		sub := ex.acc.SubAccounts[currency]
 		data = append(data, goex.FloatToString(sub.Amount, 10))
 		data = append(data, goex.FloatToString(sub.ForzenAmount, 10))
-		if currency.Eq(ex.quoteCurrency) {
-			netAsset += sub.Amount + sub.ForzenAmount
-		} else {
-			pair := goex.NewCurrencyPair(currency, ex.quoteCurrency)
-			if ex.backTestDataType == model.BackTestDataType_KLine {
-				netAsset += (sub.Amount + sub.ForzenAmount) * ex.currKline.Close
-			} else {
-				ticker, err := ex.GetTicker(pair)
-				if err != nil {
-					log.Println("[ERROR] GetTicker CurrencyPair=", pair.ToSymbol(""), ",error=", err)
-					continue
-				}
-				netAsset += (sub.Amount + sub.ForzenAmount) * ticker.Buy
-			}
+		if ex.marginTrade {
+			data = append(data, goex.FloatToString(sub.LoanAmount, 10))
 		}
 	}
-	data = append(data, goex.FloatToString(netAsset, 10))
+	data = append(data, goex.FloatToString(ex.netAsset(), 10))
 
 	csvW.Write(data)
 	csvW.Flush()
 }
+
+//以净值币种计价的净资产
+func (ex *ExchangeSim) NetAsset() float64 {
+	ex.RLock()
+	defer ex.RUnlock()
+	return ex.netAsset()
+}
+
+func (ex *ExchangeSim) netAsset() float64 {
+	netAsset := 0.0
+	for _, currency := range ex.sortedCurrencies {
+		sub := ex.acc.SubAccounts[currency]
+		amount := sub.Amount + sub.ForzenAmount - sub.LoanAmount
+		if amount == 0 {
+			continue
+		}
+		price, ok := ex.valuationPrice(currency)
+		if !ok {
+			log.Println("[ERROR] no price to value", currency.Symbol, "in", ex.quoteCurrency.Symbol)
+			continue
+		}
+		netAsset += amount * price
+	}
+	return netAsset
+}
@@ -1,11 +1,13 @@
 package sim
 
 import (
+	"encoding/csv"
 	"fmt"
 	"github.com/nntaoli-project/goex"
 	"github.com/nntaoli-project/goex_backtest/model"
 	"github.com/stretchr/testify/assert"
 	"math"
+	"os"
 	"testing"
 	"time"
 )
@@ -33,6 +35,14 @@ var sim = NewExchangeSim(model.ExchangeSimConfig{
 	UnGzip:            false,
 })
 
+//包级的sim在初始化时就创建了快照文件,所有测试结束后统一删除生成的快照和成交文件
+func TestMain(m *testing.M) {
+	code := m.Run()
+	os.Remove(fmt.Sprintf(AssetSnapshotCsvFileName, goex.BINANCE))
+	os.Remove(fmt.Sprintf(FillsCsvFileName, goex.BINANCE))
+	os.Exit(code)
+}
+
 func TestExchangeSim_GetAccount(t *testing.T) {
 	acc, _ := sim.GetAccount()
 	assert.Equal(t, 1.0, acc.SubAccounts[goex.BTC].Amount)
@@ -104,3 +114,357 @@ func TestExchangeSim_CancelOrder(t *testing.T) {
 	expectedBtc := 1.0 - bid.Amount
 	assert.Equal(t, expectedBtc, acc.SubAccounts[goex.BTC].Amount)
 }
+
+func newTestExchangeSim(dataType model.BackTestDataType) *ExchangeSim {
+	ex := NewExchangeSim(model.ExchangeSimConfig{
+		ExName:               goex.BINANCE,
+		TakerFee:             0.0002,
+		MakerFee:             -0.0001,
+		QuoteCurrency:        goex.USDT,
+		SupportCurrencyPairs: []goex.CurrencyPair{goex.BTC_USDT},
+		Account: goex.Account{
+			SubAccounts: map[goex.Currency]goex.SubAccount{
+				goex.BTC:  {Currency: goex.BTC, Amount: 1},
+				goex.USDT: {Currency: goex.USDT, Amount: 100000},
+			},
+		},
+		BackTestStartTime:   time.Date(2020, 03, 12, 0, 0, 0, 0, time.Local),
+		BackTestEndTime:     time.Date(2020, 03, 12, 0, 0, 0, 0, time.Local),
+		BackTestData:        dataType,
+		MarketOrderSlippage: 0.001,
+	})
+	setTestMarket(ex, dataType)
+	return ex
+}
+
+//BTC_USDT的初始深度和K线,按回测数据类型最后推送对应的行情
+func setTestMarket(ex *ExchangeSim, dataType model.BackTestDataType) {
+	depth := goex.Depth{
+		Pair:  goex.BTC_USDT,
+		UTime: time.Date(2020, 03, 12, 0, 0, 0, 0, time.Local),
+		AskList: goex.DepthRecords{
+			{Price: 7003, Amount: 1},
+			{Price: 7002, Amount: 1},
+			{Price: 7001, Amount: 0.5},
+		},
+		BidList: goex.DepthRecords{
+			{Price: 7000, Amount: 0.5},
+			{Price: 6999, Amount: 1},
+			{Price: 6998, Amount: 1},
+		},
+	}
+	kline := goex.Kline{
+		Pair:      goex.BTC_USDT,
+		Timestamp: 1583971200,
+		Open:      6990,
+		High:      7010,
+		Low:       6980,
+		Close:     7000,
+		Vol:       10,
+	}
+	if dataType == model.BackTestDataType_KLine {
+		ex.updateDepth(depth)
+		ex.updateKline(kline)
+	} else {
+		ex.updateKline(kline)
+		ex.updateDepth(depth)
+	}
+}
+
+func TestExchangeSim_MarketBuy(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+
+	ord, err := ex.MarketBuy("5251", "", goex.BTC_USDT)
+	assert.Nil(t, err)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.InDelta(t, 0.75, ord.DealAmount, 1e-9)
+	assert.InDelta(t, 5251/0.75, ord.AvgPrice, 1e-6)
+
+	acc, _ := ex.GetAccount()
+	assert.InDelta(t, 1+0.75-0.75*ex.takerFee, acc.SubAccounts[goex.BTC].Amount, 1e-9)
+	assert.InDelta(t, 100000-5251.0, acc.SubAccounts[goex.USDT].Amount, 1e-6)
+	assert.InDelta(t, 0.0, acc.SubAccounts[goex.USDT].ForzenAmount, 1e-6)
+}
+
+func TestExchangeSim_MarketBuy_DepthExhausted(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+
+	ord, err := ex.MarketBuy("50000", "", goex.BTC_USDT)
+	assert.Nil(t, err)
+	assert.Equal(t, goex.ORDER_CANCEL, ord.Status)
+	assert.InDelta(t, 2.5, ord.DealAmount, 1e-9)
+
+	cost := 7001*0.5 + 7002*1 + 7003*1
+	acc, _ := ex.GetAccount()
+	assert.InDelta(t, 100000-cost, acc.SubAccounts[goex.USDT].Amount, 1e-6)
+	assert.InDelta(t, 0.0, acc.SubAccounts[goex.USDT].ForzenAmount, 1e-6)
+}
+
+func TestExchangeSim_MarketSell(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+
+	ord, err := ex.MarketSell("1", "", goex.BTC_USDT)
+	assert.Nil(t, err)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.InDelta(t, 6999.5, ord.AvgPrice, 1e-8)
+
+	income := 7000*0.5 + 6999*0.5
+	acc, _ := ex.GetAccount()
+	assert.InDelta(t, 0.0, acc.SubAccounts[goex.BTC].Amount+acc.SubAccounts[goex.BTC].ForzenAmount, 1e-9)
+	assert.InDelta(t, 100000+income-income*ex.takerFee, acc.SubAccounts[goex.USDT].Amount, 1e-6)
+}
+
+func TestExchangeSim_MarketOrder_KLine(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_KLine)
+
+	ord, _ := ex.MarketBuy("7007", "", goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.InDelta(t, 7007.0, ord.AvgPrice, 1e-8)
+	assert.InDelta(t, 1.0, ord.DealAmount, 1e-9)
+
+	ord, _ = ex.MarketSell("1", "", goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.InDelta(t, 6993.0, ord.AvgPrice, 1e-8)
+}
+
+func TestExchangeSim_MarketOrder_KLineNoMarketPrice(t *testing.T) {
+	ex := NewExchangeSim(model.ExchangeSimConfig{
+		ExName:               goex.BINANCE,
+		TakerFee:             0.0002,
+		QuoteCurrency:        goex.USDT,
+		SupportCurrencyPairs: []goex.CurrencyPair{goex.BTC_USDT},
+		Account: goex.Account{
+			SubAccounts: map[goex.Currency]goex.SubAccount{
+				goex.BTC:  {Currency: goex.BTC, Amount: 1},
+				goex.USDT: {Currency: goex.USDT, Amount: 100000},
+			},
+		},
+		BackTestData: model.BackTestDataType_KLine,
+	})
+
+	ord, err := ex.MarketBuy("100", "", goex.BTC_USDT)
+	assert.Nil(t, ord)
+	assert.Equal(t, NoMarketPriceError, err)
+
+	ord, err = ex.MarketSell("0.5", "", goex.BTC_USDT)
+	assert.Nil(t, ord)
+	assert.Equal(t, NoMarketPriceError, err)
+
+	acc, _ := ex.GetAccount()
+	assert.Equal(t, 1.0, acc.SubAccounts[goex.BTC].Amount)
+	assert.Equal(t, 100000.0, acc.SubAccounts[goex.USDT].Amount)
+	assert.Equal(t, 0.0, acc.SubAccounts[goex.BTC].ForzenAmount)
+}
+
+func TestExchangeSim_LimitBuy_PostOnly(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+
+	ord, err := ex.LimitBuy("0.1", "7001", goex.BTC_USDT, goex.PostOnly)
+	assert.Nil(t, err)
+	assert.Equal(t, goex.ORDER_REJECT, ord.Status)
+	assert.Equal(t, 0.0, ord.DealAmount)
+
+	ord, _ = ex.LimitBuy("0.1", "7000", goex.BTC_USDT, goex.PostOnly)
+	assert.Equal(t, goex.ORDER_UNFINISH, ord.Status)
+
+	acc, _ := ex.GetAccount()
+	assert.InDelta(t, 700.0, acc.SubAccounts[goex.USDT].ForzenAmount, 1e-8)
+	assert.InDelta(t, 100000-700.0, acc.SubAccounts[goex.USDT].Amount, 1e-8)
+}
+
+func TestExchangeSim_LimitBuy_IOC(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+
+	ord, err := ex.LimitBuy("1", "7001", goex.BTC_USDT, goex.Ioc)
+	assert.Nil(t, err)
+	assert.Equal(t, goex.ORDER_CANCEL, ord.Status)
+	assert.InDelta(t, 0.5, ord.DealAmount, 1e-9)
+
+	acc, _ := ex.GetAccount()
+	assert.InDelta(t, 0.0, acc.SubAccounts[goex.USDT].ForzenAmount, 1e-8)
+	assert.InDelta(t, 100000-3500.5, acc.SubAccounts[goex.USDT].Amount, 1e-8)
+
+	unfinished, _ := ex.GetUnfinishOrders(goex.BTC_USDT)
+	assert.Equal(t, 0, len(unfinished))
+}
+
+func TestExchangeSim_LimitSell_FOK(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+
+	ord, _ := ex.LimitSell("1", "7000", goex.BTC_USDT, goex.Fok)
+	assert.Equal(t, goex.ORDER_CANCEL, ord.Status)
+	assert.Equal(t, 0.0, ord.DealAmount)
+
+	acc, _ := ex.GetAccount()
+	assert.Equal(t, 1.0, acc.SubAccounts[goex.BTC].Amount)
+	assert.Equal(t, 0.0, acc.SubAccounts[goex.BTC].ForzenAmount)
+
+	ord, _ = ex.LimitSell("1", "6999", goex.BTC_USDT, goex.Fok)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.InDelta(t, 1.0, ord.DealAmount, 1e-9)
+}
+
+func TestExchangeSim_LiquidityConsumption(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+	depth := ex.market(goex.BTC_USDT).depth
+
+	ord, _ := ex.LimitBuy("0.5", "7001", goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+
+	//同一快照内,7001的卖单已经被吃完
+	ord, _ = ex.LimitBuy("0.5", "7001", goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_UNFINISH, ord.Status)
+
+	ord2, _ := ex.MarketBuy("7002", "", goex.BTC_USDT)
+	assert.InDelta(t, 7002.0, ord2.AvgPrice, 1e-8)
+
+	//下一个快照到来,挂单重新撮合
+	ex.updateDepth(depth)
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.InDelta(t, 7001.0, ord.AvgPrice, 1e-8)
+}
+
+func TestExchangeSim_QueuePosition(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+	ex.queueModel = true
+	depth := ex.market(goex.BTC_USDT).depth
+
+	ord, _ := ex.LimitBuy("0.1", "7000", goex.BTC_USDT)
+	assert.Equal(t, 0.5, ex.queueAhead[ord.OrderID2])
+
+	//同价位挂单减少
+	depth.BidList = goex.DepthRecords{{Price: 7000, Amount: 0.3}, {Price: 6999, Amount: 1}}
+	ex.updateDepth(depth)
+	assert.Equal(t, 0.3, ex.queueAhead[ord.OrderID2])
+
+	//卖单挂到7000,先成交给前面排队的挂单
+	depth.AskList = goex.DepthRecords{{Price: 7001, Amount: 1}, {Price: 7000, Amount: 0.2}}
+	ex.updateDepth(depth)
+	assert.InDelta(t, 0.1, ex.queueAhead[ord.OrderID2], 1e-9)
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_UNFINISH, ord.Status)
+
+	//7000的买单已经被吃光
+	depth.BidList = goex.DepthRecords{{Price: 6999, Amount: 1}}
+	ex.updateDepth(depth)
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.Equal(t, 7000.0, ord.AvgPrice)
+}
+
+func TestExchangeSim_QueuePosition_TradeThrough(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+	ex.queueModel = true
+	depth := ex.market(goex.BTC_USDT).depth
+
+	ord, _ := ex.LimitSell("0.1", "7001", goex.BTC_USDT)
+	assert.Equal(t, 0.5, ex.queueAhead[ord.OrderID2])
+
+	depth.BidList = goex.DepthRecords{{Price: 7001.5, Amount: 1}, {Price: 7000, Amount: 1}}
+	ex.updateDepth(depth)
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.Equal(t, 7001.5, ord.AvgPrice)
+}
+
+func TestExchangeSim_KLine_LimitOrder(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_KLine)
+
+	farBuy, _ := ex.LimitBuy("0.1", "1", goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_UNFINISH, farBuy.Status)
+
+	takerBuy, _ := ex.LimitBuy("0.1", "7000", goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, takerBuy.Status)
+	assert.Equal(t, 7000.0, takerBuy.AvgPrice)
+	assert.InDelta(t, 0.1*ex.takerFee, takerBuy.Fee, 1e-12)
+
+	makerBuy, _ := ex.LimitBuy("0.1", "6985", goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_UNFINISH, makerBuy.Status)
+
+	ex.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 6995, High: 7000, Low: 6984, Close: 6990, Vol: 10})
+
+	makerBuy, _ = ex.GetOneOrder(makerBuy.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, makerBuy.Status)
+	assert.Equal(t, 6985.0, makerBuy.AvgPrice)
+	assert.InDelta(t, 0.1*ex.makerFee, makerBuy.Fee, 1e-7)
+
+	farBuy, _ = ex.GetOneOrder(farBuy.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_UNFINISH, farBuy.Status)
+}
+
+func TestExchangeSim_KLine_VolumeRatio(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_KLine)
+	ex.klineVolumeRatio = 0.1
+
+	ord, _ := ex.LimitBuy("3", "7100", goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_PART_FINISH, ord.Status)
+	assert.InDelta(t, 1.0, ord.DealAmount, 1e-9)
+
+	ex.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 7010, High: 7020, Low: 7005, Close: 7015, Vol: 10})
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.InDelta(t, 2.0, ord.DealAmount, 1e-9)
+	assert.InDelta(t, 7005.0, ord.AvgPrice, 1e-8)
+}
+
+func TestExchangeSim_KLine_PricePath(t *testing.T) {
+	bar := goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 7000, High: 7030, Low: 6970, Close: 7000, Vol: 10}
+
+	for _, c := range []struct {
+		path      model.KlinePathType
+		firstSide goex.TradeSide
+	}{
+		{model.KlinePathType_OHLC, goex.SELL},
+		{model.KlinePathType_OLHC, goex.BUY},
+	} {
+		ex := newTestExchangeSim(model.BackTestDataType_KLine)
+		ex.klinePath = c.path
+		ex.klineVolumeRatio = 0.1
+
+		buy, _ := ex.LimitBuy("1", "6980", goex.BTC_USDT)
+		sell, _ := ex.LimitSell("1", "7020", goex.BTC_USDT)
+		ex.updateKline(bar)
+
+		buy, _ = ex.GetOneOrder(buy.OrderID2, goex.BTC_USDT)
+		sell, _ = ex.GetOneOrder(sell.OrderID2, goex.BTC_USDT)
+		if c.firstSide == goex.SELL {
+			assert.Equal(t, goex.ORDER_FINISH, sell.Status)
+			assert.Equal(t, goex.ORDER_UNFINISH, buy.Status)
+		} else {
+			assert.Equal(t, goex.ORDER_FINISH, buy.Status)
+			assert.Equal(t, goex.ORDER_UNFINISH, sell.Status)
+		}
+	}
+}
+
+func TestExchangeSim_SnapshotInterval(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+	ex.snapshotInterval = 60 * 1000
+
+	depth := ex.market(goex.BTC_USDT).depth
+	start := time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC)
+	for _, sec := range []int{1, 30, 61, 125} {
+		depth.UTime = start.Add(time.Duration(sec) * time.Second)
+		ex.updateDepth(depth)
+	}
+
+	f, err := os.Open(fmt.Sprintf(AssetSnapshotCsvFileName, ex.name))
+	assert.Nil(t, err)
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	assert.Nil(t, err)
+
+	assert.Equal(t, "Timestamp", records[0][0])
+	startMs := start.UnixNano() / int64(time.Millisecond)
+	series := netAssetSeries(records)
+	assert.Len(t, series, 3)
+	assert.Equal(t, startMs+1000, series[0][0])
+	assert.Equal(t, startMs+61*1000, series[1][0])
+	assert.Equal(t, startMs+125*1000, series[2][0])
+	assert.InDelta(t, ex.NetAsset(), series[2][1], 1e-6)
+
+	//旧的快照文件没有时间列,按行号画
+	series = netAssetSeries([][]string{{"USDT_available", "NetAsset"}, {"1", "1"}, {"2", "2"}})
+	assert.Equal(t, 1, series[1][0])
+	assert.Equal(t, 2.0, series[1][1])
+}
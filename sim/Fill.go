@@ -0,0 +1,117 @@
+package sim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/nntaoli-project/goex"
+	"log"
+	"os"
+)
+
+var FillsCsvFileName = "%s_fills.csv"
+
+//一笔成交,一个订单可以有多笔成交
+type Fill struct {
+	TradeId     int64 //按成交顺序从1开始
+	OrderId     string
+	Pair        goex.CurrencyPair
+	Side        goex.TradeSide
+	Price       float64
+	Amount      float64
+	Fee         float64
+	FeeCurrency goex.Currency
+	IsTaker     bool
+	Timestamp   int64 //毫秒
+	OType       int   //合约订单的开平仓类型,现货为0
+}
+
+var fillsCsvHeader = []string{"TradeId", "OrderId", "Pair", "Side", "Price", "Amount", "Fee", "FeeCurrency", "Role", "Timestamp"}
+
+func createFillsCsv(exName string) string {
+	csvFile := fmt.Sprintf(FillsCsvFileName, exName)
+	f, err := os.OpenFile(csvFile, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0744)
+	if err != nil {
+		panic(err)
+	}
+
+	csvW := csv.NewWriter(f)
+	csvW.Write(fillsCsvHeader)
+	csvW.Flush()
+	f.Close()
+
+	return csvFile
+}
+
+func (ex *ExchangeSim) recordFill(ord *goex.Order, price, amount, fee float64, isTaker bool) {
+	fill := Fill{
+		TradeId:     int64(len(ex.fills) + 1),
+		OrderId:     ord.OrderID2,
+		Pair:        ord.Currency,
+		Side:        ord.Side,
+		Price:       price,
+		Amount:      amount,
+		Fee:         fee,
+		FeeCurrency: ex.ledger.feeCurrency(*ord),
+		IsTaker:     isTaker,
+		Timestamp:   ex.now(),
+		OType:       ex.ledger.openType(*ord),
+	}
+	ex.fills = append(ex.fills, fill)
+	ex.tracker.onFill(fill)
+
+	if ex.fillsCsvFile != "" {
+		ex.writeFill(fill)
+	}
+}
+
+func (ex *ExchangeSim) writeFill(fill Fill) {
+	f, err := os.OpenFile(ex.fillsCsvFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0744)
+	if err != nil {
+		panic(err)
+	}
+
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			log.Println("close file error=", err)
+		}
+	}()
+
+	role := "maker"
+	if fill.IsTaker {
+		role = "taker"
+	}
+
+	csvW := csv.NewWriter(f)
+	csvW.Write([]string{
+		fmt.Sprint(fill.TradeId),
+		fill.OrderId,
+		fill.Pair.ToSymbol("_"),
+		fill.Side.String(),
+		goex.FloatToString(fill.Price, 8),
+		goex.FloatToString(fill.Amount, 8),
+		goex.FloatToString(fill.Fee, 8),
+		fill.FeeCurrency.Symbol,
+		role,
+		fmt.Sprint(fill.Timestamp),
+	})
+	csvW.Flush()
+}
+
+//查询币对的全部成交记录,按成交时间排序
+func (ex *ExchangeSim) GetFills(currencyPair goex.CurrencyPair) []Fill {
+	ex.RLock()
+	defer ex.RUnlock()
+
+	var fills []Fill
+	for _, fill := range ex.fills {
+		if fill.Pair.Eq(currencyPair) {
+			fills = append(fills, fill)
+		}
+	}
+	return fills
+}
+
+func (f *FutureExchangeSim) GetFills(currencyPair goex.CurrencyPair) []Fill {
+	return f.engine.GetFills(currencyPair)
+}
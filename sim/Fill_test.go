@@ -0,0 +1,36 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExchangeSim_GetFills(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+
+	buy, _ := ex.LimitBuy("1", "7001", goex.BTC_USDT)
+	sell, _ := ex.LimitSell("0.5", "6999", goex.BTC_USDT)
+
+	fills := ex.GetFills(goex.BTC_USDT)
+	assert.Len(t, fills, 2)
+
+	//买单手续费扣基础币
+	assert.Equal(t, int64(1), fills[0].TradeId)
+	assert.Equal(t, buy.OrderID2, fills[0].OrderId)
+	assert.Equal(t, goex.BUY, fills[0].Side)
+	assert.Equal(t, 7001.0, fills[0].Price)
+	assert.Equal(t, 0.5, fills[0].Amount)
+	assert.InDelta(t, 0.5*0.0002, fills[0].Fee, 1e-12)
+	assert.Equal(t, goex.BTC, fills[0].FeeCurrency)
+	assert.True(t, fills[0].IsTaker)
+
+	//卖单手续费扣计价币
+	assert.Equal(t, sell.OrderID2, fills[1].OrderId)
+	assert.Equal(t, 7000.0, fills[1].Price)
+	assert.Equal(t, goex.USDT, fills[1].FeeCurrency)
+	assert.InDelta(t, 0.5*7000*0.0002, fills[1].Fee, 1e-8)
+
+	assert.Len(t, ex.GetFills(goex.ETH_USDT), 0)
+}
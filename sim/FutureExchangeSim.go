@@ -0,0 +1,715 @@
+package sim
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/loader"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/nntaoli-project/goex_backtest/util"
+	"log"
+	"math"
+	"os"
+	"time"
+)
+
+var (
+	InvalidOpenTypeError           = errors.New("invalid open type")
+	NoMarketPriceError             = errors.New("no market price")
+	FutureAssetSnapshotCsvFileName = "%s_future_asset_snapshot.csv"
+)
+
+const futureMarketProtectRatio = 0.05 //合约市价单以对手价上下浮动5%的限价IOC单成交
+
+//单向持仓
+type futurePosition struct {
+	amount     float64 //持仓数量(基础币)
+	available  float64 //可平数量,已扣除平仓挂单
+	avgPrice   float64
+	margin     float64 //占用的保证金
+	profitReal float64
+	createTime int64
+}
+
+type futureOrderInfo struct {
+	oType        int
+	lever        float64
+	contractType string
+}
+
+//U本位永续合约回测:双向持仓,全仓保证金,订单撮合复用现货回测引擎
+type FutureExchangeSim struct {
+	engine         *ExchangeSim
+	marginCurrency goex.Currency
+	balance        float64 //钱包余额
+	orderMargin    float64 //开仓挂单冻结的保证金
+	profitReal     float64
+	leverage       float64
+	contractValue  float64
+	levers         map[string]float64 //每个币对设置的杠杆倍数
+	longs          map[string]*futurePosition
+	shorts         map[string]*futurePosition
+	orders         map[string]*futureOrderInfo
+	fundingLoaders map[string]*loader.FundingRateDataLoader
+	fundingFees    []FundingFee
+	fundingTotal   float64 //累计资金费用,正为收入
+
+	maintenanceMarginRate float64
+	liquidationFeeRate    float64
+	liquidations          []LiquidationEvent
+	checkedKlines         map[string]int64   //每个币对已按价格路径检查过强平的K线时间(毫秒)
+	pathPrices            map[string]float64 //检查K线价格路径时临时替代的标记价格
+}
+
+//资金费用结算记录
+type FundingFee struct {
+	Pair      goex.CurrencyPair
+	Timestamp int64   //结算时间(毫秒)
+	Rate      float64 //资金费率
+	MarkPrice float64
+	Amount    float64 //正为收入,负为支出
+}
+
+func NewFutureExchangeSim(config model.ExchangeSimConfig) *FutureExchangeSim {
+	f := &FutureExchangeSim{
+		engine:         newExchangeSim(config),
+		marginCurrency: config.QuoteCurrency,
+		balance:        config.Account.SubAccounts[config.QuoteCurrency].Amount,
+		leverage:       config.Leverage,
+		contractValue:  config.ContractValue,
+		levers:         make(map[string]float64, 1),
+		longs:          make(map[string]*futurePosition, 1),
+		shorts:         make(map[string]*futurePosition, 1),
+		orders:         make(map[string]*futureOrderInfo, 100),
+		fundingLoaders: make(map[string]*loader.FundingRateDataLoader, 1),
+
+		maintenanceMarginRate: config.MaintenanceMarginRate,
+		liquidationFeeRate:    config.LiquidationFeeRate,
+		checkedKlines:         make(map[string]int64, 1),
+		pathPrices:            make(map[string]float64, 1),
+	}
+	f.engine.ledger = f
+	f.engine.pathCheck = f.checkPathPoint
+	f.engine.tracker.inventory = nil //合约账户没有现货初始持仓
+
+	if f.leverage <= 0 {
+		f.leverage = 10
+	}
+	if f.contractValue <= 0 {
+		f.contractValue = 1
+	}
+	if f.maintenanceMarginRate <= 0 {
+		f.maintenanceMarginRate = 0.005
+	}
+	if f.liquidationFeeRate <= 0 {
+		f.liquidationFeeRate = 0.005
+	}
+
+	for _, pair := range config.SupportCurrencyPairs {
+		f.longs[pair.ToSymbol("_")] = &futurePosition{}
+		f.shorts[pair.ToSymbol("_")] = &futurePosition{}
+		f.fundingLoaders[pair.ToSymbol("_")] = loader.NewFundingRateDataLoader(model.DataConfig{
+			Ex:       config.ExName,
+			Pair:     pair,
+			StarTime: config.BackTestStartTime,
+			EndTime:  config.BackTestEndTime,
+			UnGzip:   config.UnGzip,
+		})
+	}
+
+	header := []string{
+		"Timestamp",
+		fmt.Sprintf("%s_balance", f.marginCurrency.Symbol),
+		fmt.Sprintf("%s_position_margin", f.marginCurrency.Symbol),
+		fmt.Sprintf("%s_order_margin", f.marginCurrency.Symbol),
+		fmt.Sprintf("%s_unrealized_profit", f.marginCurrency.Symbol),
+		fmt.Sprintf("%s_funding", f.marginCurrency.Symbol),
+	}
+	for _, pair := range config.SupportCurrencyPairs {
+		header = append(header, fmt.Sprintf("%s_long", pair.ToSymbol("_")))
+		header = append(header, fmt.Sprintf("%s_short", pair.ToSymbol("_")))
+	}
+	header = append(header, "NetAsset")
+
+	csvFile := fmt.Sprintf(FutureAssetSnapshotCsvFileName, f.engine.name)
+	file, err := os.OpenFile(csvFile, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0744)
+	if err != nil {
+		panic(err)
+	}
+
+	csvW := csv.NewWriter(file)
+	csvW.Write(header)
+	csvW.Flush()
+	file.Close()
+
+	f.engine.fillsCsvFile = createFillsCsv(f.engine.name)
+
+	return f
+}
+
+func NewFutureExchangeSimWithTomlConfig(ex string) *FutureExchangeSim {
+	c, err := util.LoadTomlConfig(fmt.Sprintf("%s_future_sim.toml", ex))
+	if err != nil {
+		panic("not found toml config")
+	}
+	return NewFutureExchangeSim(c)
+}
+
+//开平仓类型对应的买卖方向
+func futureSide(openType int) (goex.TradeSide, bool) {
+	switch openType {
+	case goex.OPEN_BUY, goex.CLOSE_SELL:
+		return goex.BUY, true
+	case goex.OPEN_SELL, goex.CLOSE_BUY:
+		return goex.SELL, true
+	}
+	return 0, false
+}
+
+func isOpen(openType int) bool {
+	return openType == goex.OPEN_BUY || openType == goex.OPEN_SELL
+}
+
+func (f *FutureExchangeSim) position(pair goex.CurrencyPair, openType int) *futurePosition {
+	key := pair.ToSymbol("_")
+	positions := f.shorts
+	if openType == goex.OPEN_BUY || openType == goex.CLOSE_BUY {
+		positions = f.longs
+	}
+	if positions[key] == nil {
+		positions[key] = &futurePosition{}
+	}
+	return positions[key]
+}
+
+func (f *FutureExchangeSim) lever(pair goex.CurrencyPair) float64 {
+	if lever, ok := f.levers[pair.ToSymbol("_")]; ok {
+		return lever
+	}
+	return f.leverage
+}
+
+//标记价格
+func (f *FutureExchangeSim) markPrice(pair goex.CurrencyPair) float64 {
+	if price, ok := f.pathPrices[pair.ToSymbol("_")]; ok {
+		return price
+	}
+	return f.engine.lastPrice(pair)
+}
+
+func (f *FutureExchangeSim) unrealizedProfit(pair goex.CurrencyPair, long bool) float64 {
+	pos := f.position(pair, goex.OPEN_SELL)
+	if long {
+		pos = f.position(pair, goex.OPEN_BUY)
+	}
+	if pos.amount == 0 {
+		return 0
+	}
+	profit := (f.markPrice(pair) - pos.avgPrice) * pos.amount
+	if !long {
+		profit = -profit
+	}
+	return profit
+}
+
+//全部持仓的未实现盈亏和占用保证金
+func (f *FutureExchangeSim) positionSummary() (unrealized, margin float64) {
+	for _, pair := range f.engine.supportCurrencyPairs {
+		unrealized += f.unrealizedProfit(pair, true) + f.unrealizedProfit(pair, false)
+		margin += f.position(pair, goex.OPEN_BUY).margin + f.position(pair, goex.OPEN_SELL).margin
+	}
+	return
+}
+
+//可用保证金
+func (f *FutureExchangeSim) availableBalance() float64 {
+	unrealized, margin := f.positionSummary()
+	return f.balance + unrealized - margin - f.orderMargin
+}
+
+//开仓冻结保证金,平仓冻结可平数量
+func (f *FutureExchangeSim) frozenAsset(order goex.Order) error {
+	info := f.orders[order.OrderID2]
+	if info == nil {
+		return InvalidOpenTypeError
+	}
+
+	if isOpen(info.oType) {
+		margin := order.Amount * order.Price / info.lever
+		if f.availableBalance() < margin {
+			return InsufficientError
+		}
+		f.orderMargin += margin
+		return nil
+	}
+
+	pos := f.position(order.Currency, info.oType)
+	if pos.available < order.Amount-1e-12 {
+		return InsufficientError
+	}
+	pos.available -= order.Amount
+
+	return nil
+}
+
+//成交后更新持仓和保证金,撤单时释放冻结
+func (f *FutureExchangeSim) unFrozenAsset(fee, matchAmount, matchPrice float64, order goex.Order) {
+	info := f.orders[order.OrderID2]
+	pos := f.position(order.Currency, info.oType)
+
+	if order.Status == goex.ORDER_CANCEL || order.Status == goex.ORDER_REJECT {
+		remain := order.Amount - order.DealAmount
+		if isOpen(info.oType) {
+			f.orderMargin = math.Max(f.orderMargin-remain*order.Price/info.lever, 0)
+		} else {
+			pos.available += remain
+		}
+		return
+	}
+
+	f.balance -= fee
+
+	if isOpen(info.oType) {
+		f.orderMargin = math.Max(f.orderMargin-matchAmount*order.Price/info.lever, 0)
+		if pos.amount == 0 {
+			pos.createTime = f.engine.now()
+		}
+		pos.avgPrice = (pos.avgPrice*pos.amount + matchPrice*matchAmount) / (pos.amount + matchAmount)
+		pos.amount += matchAmount
+		pos.available += matchAmount
+		pos.margin += matchAmount * matchPrice / info.lever
+		return
+	}
+
+	profit := (matchPrice - pos.avgPrice) * matchAmount
+	if info.oType == goex.CLOSE_SELL {
+		profit = -profit
+	}
+	pos.margin -= pos.margin * matchAmount / pos.amount
+	pos.amount -= matchAmount
+	pos.profitReal += profit
+	f.profitReal += profit
+	f.balance += profit
+
+	if pos.amount <= 1e-12 {
+		pos.amount, pos.available, pos.avgPrice, pos.margin = 0, 0, 0, 0
+	}
+}
+
+func (f *FutureExchangeSim) afterMatch() {
+	f.settleFunding()
+	f.liquidateIfNeeded()
+}
+
+//到达资金费率结算时间时按标记价格结算持仓的资金费用,多头支付、空头收取(费率为负时相反),
+//每次结算记录一行净值快照
+func (f *FutureExchangeSim) settleFunding() {
+	now := time.Unix(0, f.engine.now()*int64(time.Millisecond))
+	for _, pair := range f.engine.supportCurrencyPairs {
+		fundingLoader := f.fundingLoaders[pair.ToSymbol("_")]
+		if fundingLoader == nil {
+			continue
+		}
+		for _, funding := range fundingLoader.Next(now) {
+			long := f.position(pair, goex.OPEN_BUY)
+			short := f.position(pair, goex.OPEN_SELL)
+			if long.amount == 0 && short.amount == 0 {
+				continue
+			}
+
+			markPrice := f.markPrice(pair)
+			amount := (short.amount - long.amount) * markPrice * funding.RealizedRate
+			f.engine.tracker.onFunding(pair, goex.OPEN_BUY, -long.amount*markPrice*funding.RealizedRate)
+			f.engine.tracker.onFunding(pair, goex.OPEN_SELL, short.amount*markPrice*funding.RealizedRate)
+			f.balance += amount
+			f.fundingTotal += amount
+			f.fundingFees = append(f.fundingFees, FundingFee{
+				Pair:      pair,
+				Timestamp: funding.FundingTime.UnixNano() / int64(time.Millisecond),
+				Rate:      funding.RealizedRate,
+				MarkPrice: markPrice,
+				Amount:    amount,
+			})
+
+			f.assetSnapshot()
+		}
+	}
+}
+
+//资金费用结算历史
+func (f *FutureExchangeSim) GetFundingFees(currencyPair goex.CurrencyPair) []FundingFee {
+	f.engine.RLock()
+	defer f.engine.RUnlock()
+
+	var fees []FundingFee
+	for _, fee := range f.fundingFees {
+		if fee.Pair.Eq(currencyPair) {
+			fees = append(fees, fee)
+		}
+	}
+	return fees
+}
+
+//合约手续费按成交额以保证金币种收取
+func (f *FutureExchangeSim) tradeFee(matchAmount, matchPrice, feeRate float64, order goex.Order) float64 {
+	return matchAmount * matchPrice * feeRate
+}
+
+//钱包余额+未实现盈亏
+func (f *FutureExchangeSim) netAsset() float64 {
+	unrealized, _ := f.positionSummary()
+	return f.balance + unrealized
+}
+
+func (f *FutureExchangeSim) feeCurrency(order goex.Order) goex.Currency {
+	return f.marginCurrency
+}
+
+func (f *FutureExchangeSim) openType(order goex.Order) int {
+	info := f.orders[order.OrderID2]
+	if info == nil {
+		return 0
+	}
+	return info.oType
+}
+
+func (f *FutureExchangeSim) GetExchangeName() string {
+	return f.engine.name
+}
+
+func (f *FutureExchangeSim) GetFutureEstimatedPrice(currencyPair goex.CurrencyPair) (float64, error) {
+	return f.GetFutureIndex(currencyPair)
+}
+
+func (f *FutureExchangeSim) GetFutureTicker(currencyPair goex.CurrencyPair, contractType string) (*goex.Ticker, error) {
+	if f.engine.backTestDataType == model.BackTestDataType_Depth {
+		return f.engine.GetTicker(currencyPair)
+	}
+
+	f.engine.RLock()
+	defer f.engine.RUnlock()
+
+	k := f.engine.market(currencyPair).kline
+	return &goex.Ticker{
+		Pair: currencyPair,
+		Last: k.Close,
+		Buy:  k.Close,
+		Sell: k.Close,
+		High: k.High,
+		Low:  k.Low,
+		Vol:  k.Vol,
+		Date: uint64(f.engine.now()),
+	}, nil
+}
+
+func (f *FutureExchangeSim) GetFutureDepth(currencyPair goex.CurrencyPair, contractType string, size int) (*goex.Depth, error) {
+	return f.engine.GetDepth(size, currencyPair)
+}
+
+//永续合约没有单独的指数数据,以最新价代替
+func (f *FutureExchangeSim) GetFutureIndex(currencyPair goex.CurrencyPair) (float64, error) {
+	f.engine.RLock()
+	defer f.engine.RUnlock()
+	return f.markPrice(currencyPair), nil
+}
+
+func (f *FutureExchangeSim) GetFutureUserinfo(currencyPair ...goex.CurrencyPair) (*goex.FutureAccount, error) {
+	f.engine.RLock()
+	defer f.engine.RUnlock()
+
+	unrealized, margin := f.positionSummary()
+	rights := f.balance + unrealized
+	keepDeposit := margin + f.orderMargin
+	riskRate := 0.0
+	if keepDeposit > 0 {
+		riskRate = rights / keepDeposit
+	}
+
+	return &goex.FutureAccount{
+		FutureSubAccounts: map[goex.Currency]goex.FutureSubAccount{
+			f.marginCurrency: {
+				Currency:      f.marginCurrency,
+				AccountRights: rights,
+				KeepDeposit:   keepDeposit,
+				ProfitReal:    f.profitReal,
+				ProfitUnreal:  unrealized,
+				RiskRate:      riskRate,
+			},
+		},
+	}, nil
+}
+
+//matchPrice为1时以对手价下单,leverRate大于0时同时设置该币对的杠杆倍数
+func (f *FutureExchangeSim) PlaceFutureOrder(currencyPair goex.CurrencyPair, contractType, price, amount string, openType, matchPrice int, leverRate float64) (string, error) {
+	if leverRate > 0 {
+		f.engine.Lock()
+		f.levers[currencyPair.ToSymbol("_")] = leverRate
+		f.engine.Unlock()
+	}
+
+	var (
+		ord *goex.FutureOrder
+		err error
+	)
+	if matchPrice == 1 {
+		ord, err = f.MarketFuturesOrder(currencyPair, contractType, amount, openType)
+	} else {
+		ord, err = f.LimitFuturesOrder(currencyPair, contractType, price, amount, openType)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return ord.OrderID2, nil
+}
+
+func (f *FutureExchangeSim) LimitFuturesOrder(currencyPair goex.CurrencyPair, contractType, price, amount string, openType int, opt ...goex.LimitOrderOptionalParameter) (*goex.FutureOrder, error) {
+	return f.futureOrder(currencyPair, contractType, goex.ToFloat64(price), goex.ToFloat64(amount), openType, orderFeature(opt...))
+}
+
+//对手价下单,以最新价上下浮动一定比例的限价IOC单成交,剩余部分撤销
+func (f *FutureExchangeSim) MarketFuturesOrder(currencyPair goex.CurrencyPair, contractType, amount string, openType int) (*goex.FutureOrder, error) {
+	return f.futureOrder(currencyPair, contractType, 0, goex.ToFloat64(amount), openType, goex.ORDER_FEATURE_IOC)
+}
+
+//amount为合约张数
+func (f *FutureExchangeSim) futureOrder(pair goex.CurrencyPair, contractType string, price, amount float64, openType, feature int) (*goex.FutureOrder, error) {
+	defer f.engine.flushOrderEvents()
+	f.engine.Lock()
+	defer f.engine.Unlock()
+
+	side, ok := futureSide(openType)
+	if !ok {
+		return nil, InvalidOpenTypeError
+	}
+
+	if price <= 0 {
+		last := f.markPrice(pair)
+		if last <= 0 {
+			return nil, NoMarketPriceError
+		}
+		price = last * (1 - futureMarketProtectRatio)
+		if side == goex.BUY {
+			price = last * (1 + futureMarketProtectRatio)
+		}
+		price = f.engine.roundToTick(pair, price)
+	}
+
+	ord := goex.Order{
+		Price:     price,
+		Amount:    amount * f.contractValue,
+		OrderID2:  f.engine.idGen.Get(),
+		OrderTime: int(f.engine.now()),
+		Status:    goex.ORDER_UNFINISH,
+		Currency:  pair,
+		Side:      side,
+		Type:      "limit",
+		OrderType: feature,
+	}
+	f.orders[ord.OrderID2] = &futureOrderInfo{oType: openType, lever: f.lever(pair), contractType: contractType}
+
+	result, err := f.engine.placeOrder(ord)
+	if err != nil {
+		delete(f.orders, ord.OrderID2)
+		return nil, err
+	}
+
+	futureOrd := f.toFutureOrder(*result)
+	return &futureOrd, nil
+}
+
+func (f *FutureExchangeSim) toFutureOrder(ord goex.Order) goex.FutureOrder {
+	futureOrd := goex.FutureOrder{
+		ClientOid:    ord.Cid,
+		OrderID2:     ord.OrderID2,
+		Price:        ord.Price,
+		Amount:       ord.Amount / f.contractValue,
+		AvgPrice:     ord.AvgPrice,
+		DealAmount:   ord.DealAmount / f.contractValue,
+		OrderTime:    int64(ord.OrderTime),
+		Status:       ord.Status,
+		Currency:     ord.Currency,
+		OrderType:    ord.OrderType,
+		Fee:          ord.Fee,
+		FinishedTime: ord.FinishedTime,
+	}
+	if info := f.orders[ord.OrderID2]; info != nil {
+		futureOrd.OType = info.oType
+		futureOrd.LeverRate = info.lever
+		futureOrd.ContractName = info.contractType
+	}
+	return futureOrd
+}
+
+func (f *FutureExchangeSim) toFutureOrders(orders []goex.Order) []goex.FutureOrder {
+	f.engine.RLock()
+	defer f.engine.RUnlock()
+
+	var futureOrders []goex.FutureOrder
+	for _, ord := range orders {
+		futureOrders = append(futureOrders, f.toFutureOrder(ord))
+	}
+	return futureOrders
+}
+
+func (f *FutureExchangeSim) FutureCancelOrder(currencyPair goex.CurrencyPair, contractType, orderId string) (bool, error) {
+	return f.engine.CancelOrder(orderId, currencyPair)
+}
+
+func (f *FutureExchangeSim) GetFuturePosition(currencyPair goex.CurrencyPair, contractType string) ([]goex.FuturePosition, error) {
+	f.engine.RLock()
+	defer f.engine.RUnlock()
+
+	long := f.position(currencyPair, goex.OPEN_BUY)
+	short := f.position(currencyPair, goex.OPEN_SELL)
+	position := goex.FuturePosition{
+		BuyAmount:      long.amount / f.contractValue,
+		BuyAvailable:   long.available / f.contractValue,
+		BuyPriceAvg:    long.avgPrice,
+		BuyPriceCost:   long.avgPrice,
+		BuyProfitReal:  long.profitReal,
+		BuyProfit:      f.unrealizedProfit(currencyPair, true),
+		CreateDate:     long.createTime,
+		LeverRate:      f.lever(currencyPair),
+		SellAmount:     short.amount / f.contractValue,
+		SellAvailable:  short.available / f.contractValue,
+		SellPriceAvg:   short.avgPrice,
+		SellPriceCost:  short.avgPrice,
+		SellProfitReal: short.profitReal,
+		SellProfit:     f.unrealizedProfit(currencyPair, false),
+		Symbol:         currencyPair,
+		ContractType:   contractType,
+	}
+	if position.CreateDate == 0 {
+		position.CreateDate = short.createTime
+	}
+	if long.margin > 0 {
+		position.LongPnlRatio = position.BuyProfit / long.margin
+	}
+	if short.margin > 0 {
+		position.ShortPnlRatio = position.SellProfit / short.margin
+	}
+	position.ForceLiquPrice = f.forceLiquidationPrice(currencyPair, true)
+	if long.amount == 0 {
+		position.ForceLiquPrice = f.forceLiquidationPrice(currencyPair, false)
+	}
+
+	return []goex.FuturePosition{position}, nil
+}
+
+func (f *FutureExchangeSim) GetFutureOrders(orderIds []string, currencyPair goex.CurrencyPair, contractType string) ([]goex.FutureOrder, error) {
+	var orders []goex.FutureOrder
+	for _, id := range orderIds {
+		ord, err := f.GetFutureOrder(id, currencyPair, contractType)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, *ord)
+	}
+	return orders, nil
+}
+
+func (f *FutureExchangeSim) GetFutureOrder(orderId string, currencyPair goex.CurrencyPair, contractType string) (*goex.FutureOrder, error) {
+	ord, err := f.engine.GetOneOrder(orderId, currencyPair)
+	if ord == nil {
+		return nil, err
+	}
+	futureOrd := f.toFutureOrders([]goex.Order{*ord})[0]
+	return &futureOrd, err
+}
+
+func (f *FutureExchangeSim) GetUnfinishFutureOrders(currencyPair goex.CurrencyPair, contractType string) ([]goex.FutureOrder, error) {
+	orders, err := f.engine.GetUnfinishOrders(currencyPair)
+	if err != nil {
+		return nil, err
+	}
+
+	var pairOrders []goex.Order
+	for _, ord := range orders {
+		if ord.Currency.Eq(currencyPair) {
+			pairOrders = append(pairOrders, ord)
+		}
+	}
+	return f.toFutureOrders(pairOrders), nil
+}
+
+func (f *FutureExchangeSim) GetFutureOrderHistory(pair goex.CurrencyPair, contractType string, optional ...goex.OptionalParameter) ([]goex.FutureOrder, error) {
+	orders, err := f.engine.GetOrderHistorys(pair, optional...)
+	if err != nil {
+		return nil, err
+	}
+	return f.toFutureOrders(orders), nil
+}
+
+func (f *FutureExchangeSim) GetFee() (float64, error) {
+	return f.engine.takerFee, nil
+}
+
+func (f *FutureExchangeSim) GetContractValue(currencyPair goex.CurrencyPair) (float64, error) {
+	return f.contractValue, nil
+}
+
+func (f *FutureExchangeSim) GetDeliveryTime() (int, int, int, int) {
+	panic("not support")
+}
+
+func (f *FutureExchangeSim) GetKlineRecords(contractType string, currency goex.CurrencyPair, period goex.KlinePeriod, size int, optional ...goex.OptionalParameter) ([]goex.FutureKline, error) {
+	klines, err := f.engine.GetKlineRecords(currency, period, size, optional...)
+	if err != nil {
+		return nil, err
+	}
+
+	var futureKlines []goex.FutureKline
+	for i := range klines {
+		futureKlines = append(futureKlines, goex.FutureKline{Kline: &klines[i], Vol2: klines[i].Vol / f.contractValue})
+	}
+	return futureKlines, nil
+}
+
+func (f *FutureExchangeSim) GetTrades(contractType string, currencyPair goex.CurrencyPair, since int64) ([]goex.Trade, error) {
+	panic("not support")
+}
+
+//按标记价格记录保证金余额、持仓和净值(钱包余额+未实现盈亏)
+func (f *FutureExchangeSim) AssetSnapshot() {
+	f.engine.RLock()
+	defer f.engine.RUnlock()
+	f.assetSnapshot()
+}
+
+func (f *FutureExchangeSim) assetSnapshot() {
+	csvFile := fmt.Sprintf(FutureAssetSnapshotCsvFileName, f.engine.name)
+	file, err := os.OpenFile(csvFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0744)
+	if err != nil {
+		panic(err)
+	}
+
+	defer func() {
+		err := file.Close()
+		if err != nil {
+			log.Println("close file error=", err)
+		}
+	}()
+
+	unrealized, margin := f.positionSummary()
+	data := []string{
+		fmt.Sprint(f.engine.now()),
+		goex.FloatToString(f.balance, 10),
+		goex.FloatToString(margin, 10),
+		goex.FloatToString(f.orderMargin, 10),
+		goex.FloatToString(unrealized, 10),
+		goex.FloatToString(f.fundingTotal, 10),
+	}
+	for _, pair := range f.engine.supportCurrencyPairs {
+		data = append(data, goex.FloatToString(f.position(pair, goex.OPEN_BUY).amount, 10))
+		data = append(data, goex.FloatToString(f.position(pair, goex.OPEN_SELL).amount, 10))
+	}
+	data = append(data, goex.FloatToString(f.balance+unrealized, 10))
+
+	csvW := csv.NewWriter(file)
+	csvW.Write(data)
+	csvW.Flush()
+}
+
+var _ goex.FutureRestAPI = (*FutureExchangeSim)(nil)
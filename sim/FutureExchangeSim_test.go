@@ -0,0 +1,331 @@
+package sim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/loader"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+//测试结束时删除模拟交易所生成的快照和成交文件
+func newTestFutureExchangeSim(t *testing.T, dataType model.BackTestDataType) *FutureExchangeSim {
+	t.Cleanup(func() {
+		os.Remove(fmt.Sprintf(FutureAssetSnapshotCsvFileName, goex.BINANCE_SWAP))
+		os.Remove(fmt.Sprintf(FillsCsvFileName, goex.BINANCE_SWAP))
+	})
+	f := NewFutureExchangeSim(model.ExchangeSimConfig{
+		ExName:               goex.BINANCE_SWAP,
+		TakerFee:             0.0004,
+		MakerFee:             0.0002,
+		QuoteCurrency:        goex.USDT,
+		SupportCurrencyPairs: []goex.CurrencyPair{goex.BTC_USDT},
+		Account: goex.Account{
+			SubAccounts: map[goex.Currency]goex.SubAccount{
+				goex.USDT: {Currency: goex.USDT, Amount: 10000},
+			},
+		},
+		BackTestStartTime: time.Date(2020, 03, 12, 0, 0, 0, 0, time.Local),
+		BackTestEndTime:   time.Date(2020, 03, 12, 0, 0, 0, 0, time.Local),
+		BackTestData:      dataType,
+		Leverage:          10,
+	})
+	setTestMarket(f.engine, dataType)
+	return f
+}
+
+func TestFutureExchangeSim_OpenAndCloseLong(t *testing.T) {
+	f := newTestFutureExchangeSim(t, model.BackTestDataType_Depth)
+
+	ord, err := f.MarketFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "0.5", goex.OPEN_BUY)
+	assert.Nil(t, err)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.Equal(t, goex.OPEN_BUY, ord.OType)
+	assert.Equal(t, 7001.0, ord.AvgPrice)
+
+	positions, _ := f.GetFuturePosition(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT)
+	assert.Equal(t, 0.5, positions[0].BuyAmount)
+	assert.Equal(t, 7001.0, positions[0].BuyPriceAvg)
+
+	acc, _ := f.GetFutureUserinfo()
+	usdt := acc.FutureSubAccounts[goex.USDT]
+	assert.InDelta(t, 350.05, usdt.KeepDeposit, 1e-8)
+	assert.InDelta(t, 10000-0.5*7001*0.0004+(7000.5-7001)*0.5, usdt.AccountRights, 1e-6)
+
+	depth := f.engine.market(goex.BTC_USDT).depth
+	depth.AskList = goex.DepthRecords{{Price: 7103, Amount: 1}, {Price: 7102, Amount: 1}}
+	depth.BidList = goex.DepthRecords{{Price: 7101, Amount: 1}, {Price: 7100, Amount: 1}}
+	f.engine.updateDepth(depth)
+
+	positions, _ = f.GetFuturePosition(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT)
+	assert.InDelta(t, (7101.5-7001)*0.5, positions[0].BuyProfit, 1e-8)
+
+	ord, err = f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "7100", "0.5", goex.CLOSE_BUY)
+	assert.Nil(t, err)
+	assert.Equal(t, 7101.0, ord.AvgPrice)
+
+	acc, _ = f.GetFutureUserinfo()
+	usdt = acc.FutureSubAccounts[goex.USDT]
+	assert.InDelta(t, 50.0, usdt.ProfitReal, 1e-8)
+	assert.InDelta(t, 0.0, usdt.KeepDeposit, 1e-8)
+	assert.InDelta(t, 10000+50-0.5*7001*0.0004-0.5*7101*0.0004, usdt.AccountRights, 1e-6)
+}
+
+func TestFutureExchangeSim_Short(t *testing.T) {
+	f := newTestFutureExchangeSim(t, model.BackTestDataType_KLine)
+
+	_, err := f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "7000", "1", goex.CLOSE_SELL)
+	assert.Equal(t, InsufficientError, err)
+
+	_, err = f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "7000", "100", goex.OPEN_SELL)
+	assert.Equal(t, InsufficientError, err)
+
+	ord, err := f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "7000", "1", goex.OPEN_SELL)
+	assert.Nil(t, err)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+
+	f.engine.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 7000, High: 7110, Low: 6990, Close: 7100, Vol: 10})
+
+	positions, _ := f.GetFuturePosition(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT)
+	assert.Equal(t, 1.0, positions[0].SellAmount)
+	assert.InDelta(t, -100.0, positions[0].SellProfit, 1e-8)
+
+	//平空挂单冻结可平数量,撤单后恢复
+	ord, _ = f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "6900", "1", goex.CLOSE_SELL)
+	positions, _ = f.GetFuturePosition(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT)
+	assert.Equal(t, 0.0, positions[0].SellAvailable)
+	ok, _ := f.FutureCancelOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, ord.OrderID2)
+	assert.True(t, ok)
+	positions, _ = f.GetFuturePosition(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT)
+	assert.Equal(t, 1.0, positions[0].SellAvailable)
+
+	//开仓挂单冻结保证金,撤单后释放
+	ord, _ = f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "7200", "1", goex.OPEN_SELL)
+	acc, _ := f.GetFutureUserinfo()
+	assert.InDelta(t, 700+720, acc.FutureSubAccounts[goex.USDT].KeepDeposit, 1e-8)
+	f.FutureCancelOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, ord.OrderID2)
+	acc, _ = f.GetFutureUserinfo()
+	assert.InDelta(t, 700, acc.FutureSubAccounts[goex.USDT].KeepDeposit, 1e-8)
+
+	ord, _ = f.MarketFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "1", goex.CLOSE_SELL)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.Equal(t, 7100.0, ord.AvgPrice)
+
+	history, _ := f.GetFutureOrderHistory(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT)
+	assert.Len(t, history, 4)
+
+	acc, _ = f.GetFutureUserinfo()
+	assert.InDelta(t, -100.0, acc.FutureSubAccounts[goex.USDT].ProfitReal, 1e-8)
+}
+
+func TestFutureExchangeSim_Funding(t *testing.T) {
+	f := newTestFutureExchangeSim(t, model.BackTestDataType_KLine)
+
+	os.MkdirAll("data", 0755)
+	defer os.Remove("data")
+	fileName := fmt.Sprintf("data/%s_funding_rate_btcusdt.csv", f.GetExchangeName())
+	ioutil.WriteFile(fileName, []byte("1583971230000,0.0001\n1583971290000,-0.0002\n"), 0644)
+	defer os.Remove(fileName)
+	f.fundingLoaders["BTC_USDT"] = loader.NewFundingRateDataLoader(model.DataConfig{
+		Ex:       f.GetExchangeName(),
+		Pair:     goex.BTC_USDT,
+		StarTime: time.Date(2020, 03, 12, 0, 0, 0, 0, time.Local),
+		EndTime:  time.Date(2020, 03, 12, 0, 0, 0, 0, time.Local),
+	})
+
+	f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "7000", "1", goex.OPEN_BUY)
+	f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "7000", "3", goex.OPEN_SELL)
+	acc, _ := f.GetFutureUserinfo()
+	rights := acc.FutureSubAccounts[goex.USDT].AccountRights
+
+	//净空头2,费率为正时收取资金费
+	f.engine.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 7000, High: 7000, Low: 7000, Close: 7000, Vol: 10})
+	fees := f.GetFundingFees(goex.BTC_USDT)
+	assert.Len(t, fees, 1)
+	assert.InDelta(t, 2*7000*0.0001, fees[0].Amount, 1e-8)
+	assert.Equal(t, int64(1583971230000), fees[0].Timestamp)
+
+	f.engine.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971320, Open: 7000, High: 7000, Low: 7000, Close: 7000, Vol: 10})
+	fees = f.GetFundingFees(goex.BTC_USDT)
+	assert.Len(t, fees, 2)
+	assert.InDelta(t, -2*7000*0.0002, fees[1].Amount, 1e-8)
+
+	acc, _ = f.GetFutureUserinfo()
+	assert.InDelta(t, rights+1.4-2.8, acc.FutureSubAccounts[goex.USDT].AccountRights, 1e-8)
+
+	//每次结算记录一行净值快照
+	snapshot, _ := os.Open(fmt.Sprintf(FutureAssetSnapshotCsvFileName, f.GetExchangeName()))
+	defer snapshot.Close()
+	records, _ := csv.NewReader(snapshot).ReadAll()
+	assert.Len(t, records, 3)
+	assert.Equal(t, "USDT_funding", records[0][5])
+	assert.InDelta(t, -1.4, goex.ToFloat64(records[2][5]), 1e-8)
+
+	//资金费快照和现货的净值快照一起画进净值曲线
+	s := NewBacktestStatistics(nil, f)
+	assert.Equal(t, []string{fmt.Sprintf(FutureAssetSnapshotCsvFileName, f.GetExchangeName())}, s.assetSnapshotFiles())
+	assert.Equal(t, f.GetExchangeName(), s.exchangeName(0))
+	series := netAssetSeries(records)
+	assert.Len(t, series, 2)
+	assert.Equal(t, int64(1583971320000), series[1][0])
+	assert.InDelta(t, rights+1.4-2.8, series[1][1], 1e-8)
+
+	//资金费用计入平仓的配对交易
+	f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "7000", "1", goex.CLOSE_BUY)
+	f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "7000", "3", goex.CLOSE_SELL)
+	trips := f.GetRoundTrips(goex.BTC_USDT)
+	assert.Len(t, trips, 2)
+	assert.InDelta(t, -0.7+1.4, trips[0].Funding, 1e-8)
+	assert.InDelta(t, 2.1-4.2, trips[1].Funding, 1e-8)
+	assert.InDelta(t, trips[0].Funding-trips[0].Fee, trips[0].PnL, 1e-8)
+}
+
+func TestFutureExchangeSim_HedgeRoundTrips(t *testing.T) {
+	f := newTestFutureExchangeSim(t, model.BackTestDataType_Depth)
+
+	//同时持有多头和空头,开空不会平掉多头
+	f.MarketFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "0.5", goex.OPEN_BUY)
+	f.MarketFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "0.5", goex.OPEN_SELL)
+	assert.Len(t, f.GetRoundTrips(goex.BTC_USDT), 0)
+	positions, _ := f.GetFuturePosition(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT)
+	assert.Equal(t, 0.5, positions[0].BuyAmount)
+	assert.Equal(t, 0.5, positions[0].SellAmount)
+
+	depth := f.engine.market(goex.BTC_USDT).depth
+	depth.AskList = goex.DepthRecords{{Price: 7103, Amount: 1}, {Price: 7102, Amount: 1}}
+	depth.BidList = goex.DepthRecords{{Price: 7101, Amount: 1}, {Price: 7100, Amount: 1}}
+	f.engine.updateDepth(depth)
+
+	f.MarketFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "0.5", goex.CLOSE_BUY)
+	trips := f.GetRoundTrips(goex.BTC_USDT)
+	assert.Len(t, trips, 1)
+	assert.Equal(t, goex.BUY, trips[0].Side)
+	assert.Equal(t, 7001.0, trips[0].OpenPrice)
+	assert.Equal(t, 7101.0, trips[0].ClosePrice)
+	assert.InDelta(t, 100*0.5-(7001+7101)*0.5*0.0004, trips[0].PnL, 1e-6)
+	pnl := f.GetPositionPnL(goex.BTC_USDT)
+	assert.InDelta(t, -0.5, pnl.Amount, 1e-12)
+
+	f.MarketFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "0.5", goex.CLOSE_SELL)
+	trips = f.GetRoundTrips(goex.BTC_USDT)
+	assert.Len(t, trips, 2)
+	assert.Equal(t, goex.SELL, trips[1].Side)
+	assert.Equal(t, 7000.0, trips[1].OpenPrice)
+	assert.Equal(t, 7102.0, trips[1].ClosePrice)
+	assert.InDelta(t, -102*0.5-(7000+7102)*0.5*0.0004, trips[1].PnL, 1e-6)
+	pnl = f.GetPositionPnL(goex.BTC_USDT)
+	assert.InDelta(t, 0, pnl.Amount, 1e-12)
+	assert.InDelta(t, trips[0].PnL+trips[1].PnL, pnl.RealizedPnL, 1e-8)
+}
+
+func TestFutureExchangeSim_Liquidation(t *testing.T) {
+	f := newTestFutureExchangeSim(t, model.BackTestDataType_KLine)
+
+	f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "7000", "10", goex.OPEN_BUY)
+	pending, _ := f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "5000", "0.1", goex.OPEN_BUY)
+
+	positions, _ := f.GetFuturePosition(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT)
+	assert.InDelta(t, (70000-9972)/(10*0.995), positions[0].ForceLiquPrice, 1e-6)
+
+	f.engine.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 6600, High: 7000, Low: 6550, Close: 6600, Vol: 10})
+	assert.Len(t, f.GetLiquidations(), 0)
+
+	f.engine.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971320, Open: 6600, High: 6600, Low: 6000, Close: 6000, Vol: 10})
+	//按破产价格平仓,扣除强平手续费后权益为0,最低价低于破产价格的部分为穿仓损失
+	bankruptcy := (70000 - 9972) / (10 * 0.995)
+	liquidations := f.GetLiquidations()
+	assert.Len(t, liquidations, 1)
+	assert.InDelta(t, 10*bankruptcy*0.005, liquidations[0].Fee, 1e-7)
+	assert.InDelta(t, (bankruptcy-6000)*10, liquidations[0].Loss, 1e-7)
+
+	positions, _ = f.GetFuturePosition(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT)
+	assert.Equal(t, 0.0, positions[0].BuyAmount)
+
+	ord, _ := f.GetFutureOrder(pending.OrderID2, goex.BTC_USDT, goex.SWAP_USDT_CONTRACT)
+	assert.Equal(t, goex.ORDER_CANCEL, ord.Status)
+
+	ord, _ = f.GetFutureOrder(liquidations[0].OrderIds[0], goex.BTC_USDT, goex.SWAP_USDT_CONTRACT)
+	assert.Equal(t, ORDER_FEATURE_LIQUIDATION, ord.OrderType)
+	assert.Equal(t, goex.CLOSE_BUY, ord.OType)
+	assert.InDelta(t, bankruptcy, ord.AvgPrice, 1e-8)
+	assert.Equal(t, 10.0, ord.DealAmount)
+
+	acc, _ := f.GetFutureUserinfo()
+	assert.Equal(t, 0.0, acc.FutureSubAccounts[goex.USDT].AccountRights)
+	assert.Equal(t, 0.0, acc.FutureSubAccounts[goex.USDT].KeepDeposit)
+}
+
+func TestFutureExchangeSim_LiquidationWick(t *testing.T) {
+	for _, c := range []struct {
+		oType     int
+		closeType int
+		bar       goex.Kline
+		price     float64 //破产价格
+		loss      float64
+	}{
+		//下影线穿过多头强平价,收盘收回
+		{goex.OPEN_BUY, goex.CLOSE_BUY, goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 6600, High: 6600, Low: 5990, Close: 6500, Vol: 10},
+			(70000 - 9972) / (10 * 0.995), ((70000-9972)/(10*0.995) - 5990) * 10},
+		//上影线穿过空头强平价,收盘收回
+		{goex.OPEN_SELL, goex.CLOSE_SELL, goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 7400, High: 8000, Low: 7300, Close: 7500, Vol: 10},
+			(70000 + 9972) / (10 * 1.005), (8000 - (70000+9972)/(10*1.005)) * 10},
+	} {
+		f := newTestFutureExchangeSim(t, model.BackTestDataType_KLine)
+		f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "7000", "10", c.oType)
+		positions, _ := f.GetFuturePosition(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT)
+		if c.oType == goex.OPEN_BUY {
+			assert.True(t, c.bar.Low < positions[0].ForceLiquPrice && c.bar.Close > positions[0].ForceLiquPrice)
+		} else {
+			assert.True(t, c.bar.High > positions[0].ForceLiquPrice && c.bar.Close < positions[0].ForceLiquPrice)
+		}
+
+		f.engine.updateKline(c.bar)
+		liquidations := f.GetLiquidations()
+		assert.Len(t, liquidations, 1)
+		assert.Equal(t, int64(1583971260000), liquidations[0].Timestamp)
+		assert.InDelta(t, 10*c.price*0.005, liquidations[0].Fee, 1e-7)
+		assert.InDelta(t, c.loss, liquidations[0].Loss, 1e-7)
+
+		ord, _ := f.GetFutureOrder(liquidations[0].OrderIds[0], goex.BTC_USDT, goex.SWAP_USDT_CONTRACT)
+		assert.Equal(t, c.closeType, ord.OType)
+		assert.InDelta(t, c.price, ord.AvgPrice, 1e-8)
+
+		//同一根K线不会重复检查
+		f.engine.match()
+		assert.Len(t, f.GetLiquidations(), 1)
+	}
+}
+
+func TestFutureExchangeSim_LiquidationPathOrder(t *testing.T) {
+	bar := goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 6600, High: 7200, Low: 5990, Close: 6500, Vol: 10}
+	for _, c := range []struct {
+		path       model.KlinePathType
+		liquidated bool
+	}{
+		//先到最高价,止盈单平仓后不会强平
+		{model.KlinePathType_OHLC, false},
+		//先到最低价强平,之后才触及的止盈单不再成交
+		{model.KlinePathType_OLHC, true},
+	} {
+		f := newTestFutureExchangeSim(t, model.BackTestDataType_KLine)
+		f.engine.klinePath = c.path
+		f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "7000", "10", goex.OPEN_BUY)
+		takeProfit, _ := f.LimitFuturesOrder(goex.BTC_USDT, goex.SWAP_USDT_CONTRACT, "7100", "10", goex.CLOSE_BUY)
+
+		f.engine.updateKline(bar)
+		ord, _ := f.GetFutureOrder(takeProfit.OrderID2, goex.BTC_USDT, goex.SWAP_USDT_CONTRACT)
+		if c.liquidated {
+			assert.Len(t, f.GetLiquidations(), 1)
+			assert.Equal(t, goex.ORDER_CANCEL, ord.Status)
+		} else {
+			assert.Len(t, f.GetLiquidations(), 0)
+			assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+			assert.Equal(t, 7100.0, ord.AvgPrice)
+		}
+	}
+}
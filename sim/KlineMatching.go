@@ -0,0 +1,107 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"math"
+)
+
+//K线回测撮合:
+//1. 刚下的单与最新收盘价比较,能立即成交的以收盘价按taker成交,否则挂单等待后续K线;
+//   有下单延迟时订单在后续K线开盘时到达,改为与开盘价比较
+//2. 挂单在后续K线的最低价(买单)/最高价(卖单)触及委托价时才成交;
+//   委托价相对上一根K线收盘价可立即成交的为taker,开盘即可成交时以开盘价成交,其余按委托价以maker成交
+//3. 每根K线的可成交数量受 KlineVolumeRatio * Vol 限制
+//4. 各币对的挂单按各自的K线撮合,其它币对的K线到来时不会用下单前已走完的K线再次撮合
+func (ex *ExchangeSim) matchOrderByKlineData(ord *goex.Order, isTaker bool) {
+	m := ex.market(ord.Currency)
+	k := m.kline
+
+	if isTaker {
+		if price := ex.klineQuote(ord.Currency); marketable(*ord, price) {
+			ex.fillOrderByKline(true, price, ord)
+		}
+		return
+	}
+
+	if int64(ord.OrderTime) >= klineTime(k) {
+		return
+	}
+
+	touched := (ord.Side == goex.BUY && k.Low <= ord.Price) || (ord.Side == goex.SELL && k.High >= ord.Price)
+	if !touched || ex.touchPosition(*ord) > ex.pathLimit {
+		return
+	}
+
+	price := ord.Price
+	isTaker = m.prevKline.Close > 0 && marketable(*ord, m.prevKline.Close)
+	if isTaker && marketable(*ord, k.Open) {
+		price = k.Open
+	}
+
+	ex.fillOrderByKline(isTaker, price, ord)
+}
+
+func (ex *ExchangeSim) fillOrderByKline(isTaker bool, price float64, ord *goex.Order) {
+	m := ex.market(ord.Currency)
+	amount := math.Min(ord.Amount-ord.DealAmount, m.klineVolumeAvailable(ex.klineVolumeRatio))
+	if amount <= 0 {
+		return
+	}
+
+	if isTaker {
+		price = ex.takerPrice(ord, amount, price)
+	}
+
+	m.klineConsumedVol += ex.fillOrder(isTaker, amount, price, ord)
+	if ord.Status == goex.ORDER_FINISH {
+		ex.finishOrder(ord)
+	}
+}
+
+//当前K线剩余可成交的数量
+func (m *marketState) klineVolumeAvailable(ratio float64) float64 {
+	if ratio <= 0 {
+		return math.MaxFloat64
+	}
+	return math.Max(m.kline.Vol*ratio-m.klineConsumedVol, 0)
+}
+
+//K线价格路径的点数:开盘、最高(最低)、最低(最高)、收盘
+const klinePathPoints = 4
+
+//K线内部的价格路径
+func (ex *ExchangeSim) klinePricePath(k goex.Kline) []float64 {
+	if ex.klinePath == model.KlinePathType_OLHC {
+		return []float64{k.Open, k.Low, k.High, k.Close}
+	}
+	return []float64{k.Open, k.High, k.Low, k.Close}
+}
+
+//订单在所属币对当前K线价格路径上第一次触及委托价的位置
+func (ex *ExchangeSim) touchPosition(ord goex.Order) float64 {
+	return pathTouchPosition(ex.klinePricePath(ex.market(ord.Currency).kline), ord)
+}
+
+//价格路径第一次触及委托价的位置(0为开盘,len(path)-1为收盘),未触及返回+Inf
+func pathTouchPosition(path []float64, ord goex.Order) float64 {
+	for i, price := range path {
+		if !marketable(ord, price) {
+			continue
+		}
+		if i == 0 {
+			return 0
+		}
+		prev := path[i-1]
+		return float64(i-1) + (prev-ord.Price)/(prev-price)
+	}
+	return math.Inf(1)
+}
+
+//委托价相对price是否可以立即成交
+func marketable(ord goex.Order, price float64) bool {
+	if ord.Side == goex.BUY {
+		return ord.Price >= price
+	}
+	return ord.Price <= price
+}
@@ -0,0 +1,110 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"math"
+	"math/rand"
+	"time"
+)
+
+//固定延迟
+type FixedLatency struct {
+	Latency time.Duration
+}
+
+func NewFixedLatency(latency time.Duration) *FixedLatency {
+	return &FixedLatency{Latency: latency}
+}
+
+func (l *FixedLatency) Delay() time.Duration {
+	return l.Latency
+}
+
+//[Min, Max]之间均匀分布的延迟,相同的seed回测结果可复现
+type UniformLatency struct {
+	Min  time.Duration
+	Max  time.Duration
+	rand *rand.Rand
+}
+
+func NewUniformLatency(min, max time.Duration, seed int64) *UniformLatency {
+	return &UniformLatency{Min: min, Max: max, rand: rand.New(rand.NewSource(seed))}
+}
+
+func (l *UniformLatency) Delay() time.Duration {
+	if l.Max <= l.Min {
+		return l.Min
+	}
+	return l.Min + time.Duration(l.rand.Int63n(int64(l.Max-l.Min)+1))
+}
+
+//对数正态分布的延迟,大部分请求接近Median,偶尔出现长尾
+type LogNormalLatency struct {
+	Median time.Duration
+	Sigma  float64
+	rand   *rand.Rand
+}
+
+func NewLogNormalLatency(median time.Duration, sigma float64, seed int64) *LogNormalLatency {
+	return &LogNormalLatency{Median: median, Sigma: sigma, rand: rand.New(rand.NewSource(seed))}
+}
+
+func (l *LogNormalLatency) Delay() time.Duration {
+	return time.Duration(float64(l.Median) * math.Exp(l.Sigma*l.rand.NormFloat64()))
+}
+
+//下单或撤单请求
+type orderRequest struct {
+	arriveTime int64 //到达撮合引擎的时间(毫秒)
+	ord        *goex.Order
+	cancel     bool
+}
+
+//配置了延迟时请求进入队列等待到达撮合引擎,返回是否延迟
+func (ex *ExchangeSim) delayRequest(ord *goex.Order, cancel bool) bool {
+	if ex.orderLatency == nil && ex.marketDataLatency <= 0 {
+		return false
+	}
+
+	delay := ex.marketDataLatency
+	if ex.orderLatency != nil {
+		delay += ex.orderLatency.Delay()
+	}
+
+	arriveTime := ex.now() + int64(delay/time.Millisecond)
+	if n := len(ex.requests); n > 0 && ex.requests[n-1].arriveTime > arriveTime {
+		arriveTime = ex.requests[n-1].arriveTime //同一连接上的请求按发送顺序到达
+	}
+
+	ex.requests = append(ex.requests, &orderRequest{arriveTime: arriveTime, ord: ord, cancel: cancel})
+	if !cancel {
+		ex.unarrived[ord.OrderID2] = true
+	}
+
+	return true
+}
+
+//处理已经到达撮合引擎的请求;撤单到达时订单已经成交完成的,撤单失败
+func (ex *ExchangeSim) processArrivedRequests() {
+	now := ex.now()
+	for len(ex.requests) > 0 && ex.requests[0].arriveTime <= now {
+		req := ex.requests[0]
+		ex.requests = ex.requests[1:]
+
+		if req.cancel {
+			delete(ex.cancelling, req.ord.OrderID2)
+			if ex.finishedOrders[req.ord.OrderID2] != nil { //撤单输给了成交
+				ex.cancelErrors[req.ord.OrderID2] = CancelOrderFinishedError
+				ex.emitOrderEvent(OrderEventType_CancelRejected, req.ord)
+				continue
+			}
+			ex.cancelOrder(req.ord)
+			continue
+		}
+
+		delete(ex.unarrived, req.ord.OrderID2)
+		if ex.pendingOrders[req.ord.OrderID2] != nil { //条件单到达后开始监控触发,已被强制撤销的订单不再撮合
+			ex.submitOrder(req.ord)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestUniformLatency_Reproducible(t *testing.T) {
+	l1 := NewUniformLatency(10*time.Millisecond, 50*time.Millisecond, 1)
+	l2 := NewUniformLatency(10*time.Millisecond, 50*time.Millisecond, 1)
+	for i := 0; i < 100; i++ {
+		d := l1.Delay()
+		assert.Equal(t, d, l2.Delay())
+		assert.True(t, d >= 10*time.Millisecond && d <= 50*time.Millisecond)
+	}
+}
+
+func TestExchangeSim_OrderLatency(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+	ex.orderLatency = NewFixedLatency(100 * time.Millisecond)
+	depth := ex.market(goex.BTC_USDT).depth
+
+	//下单时看到的盘口不参与撮合
+	ord, err := ex.LimitBuy("0.5", "7001", goex.BTC_USDT)
+	assert.Nil(t, err)
+	assert.Equal(t, goex.ORDER_UNFINISH, ord.Status)
+	assert.Equal(t, 0.0, ord.DealAmount)
+
+	//订单还未到达
+	depth.UTime = depth.UTime.Add(50 * time.Millisecond)
+	ex.updateDepth(depth)
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, 0.0, ord.DealAmount)
+
+	//订单到达时盘口已经变化
+	depth.UTime = depth.UTime.Add(50 * time.Millisecond)
+	depth.AskList = goex.DepthRecords{{Price: 7005, Amount: 1}, {Price: 7000.5, Amount: 1}}
+	ex.updateDepth(depth)
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.Equal(t, 7000.5, ord.AvgPrice)
+}
+
+func TestExchangeSim_CancelLatency(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+	ex.orderLatency = NewFixedLatency(100 * time.Millisecond)
+	depth := ex.market(goex.BTC_USDT).depth
+
+	var events []*OrderEvent
+	ex.SubscribeOrderEvent(func(event *OrderEvent) {
+		events = append(events, event)
+	})
+
+	//撤单和挂单成交的竞争:成交先到达
+	fillFirst, _ := ex.LimitBuy("0.1", "6990", goex.BTC_USDT)
+	depth.UTime = depth.UTime.Add(100 * time.Millisecond)
+	ex.updateDepth(depth)
+
+	//撤单请求已发出,结果要等撤单到达撮合引擎
+	ok, err := ex.CancelOrder(fillFirst.OrderID2, goex.BTC_USDT)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	//撤单到达前成交,订单仍显示为撤单中
+	depth.UTime = depth.UTime.Add(50 * time.Millisecond)
+	depth.AskList = goex.DepthRecords{{Price: 6990, Amount: 1}}
+	ex.updateDepth(depth)
+	fillFirst, err = ex.GetOneOrder(fillFirst.OrderID2, goex.BTC_USDT)
+	assert.Nil(t, err)
+	assert.Equal(t, goex.ORDER_CANCEL_ING, fillFirst.Status)
+	assert.Equal(t, 0.1, fillFirst.DealAmount)
+	ok, err = ex.CancelOrder(fillFirst.OrderID2, goex.BTC_USDT)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	depth.UTime = depth.UTime.Add(50 * time.Millisecond)
+	ex.updateDepth(depth)
+	fillFirst, err = ex.GetOneOrder(fillFirst.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, CancelOrderFinishedError, err)
+	assert.Equal(t, goex.ORDER_FINISH, fillFirst.Status)
+	last := events[len(events)-1]
+	assert.Equal(t, OrderEventType_CancelRejected, last.Type)
+	assert.Equal(t, fillFirst.OrderID2, last.Order.OrderID2)
+	assert.Equal(t, goex.ORDER_FINISH, last.Order.Status)
+
+	ok, err = ex.CancelOrder(fillFirst.OrderID2, goex.BTC_USDT)
+	assert.False(t, ok)
+	assert.Equal(t, CancelOrderFinishedError, err)
+
+	//撤单先到达
+	cancelFirst, _ := ex.LimitBuy("0.1", "6980", goex.BTC_USDT)
+	depth.UTime = depth.UTime.Add(100 * time.Millisecond)
+	depth.AskList = goex.DepthRecords{{Price: 7000, Amount: 1}}
+	ex.updateDepth(depth)
+
+	ex.CancelOrder(cancelFirst.OrderID2, goex.BTC_USDT)
+	cancelFirst, _ = ex.GetOneOrder(cancelFirst.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_CANCEL_ING, cancelFirst.Status)
+
+	depth.UTime = depth.UTime.Add(100 * time.Millisecond)
+	depth.AskList = goex.DepthRecords{{Price: 6980, Amount: 1}}
+	ex.updateDepth(depth)
+	cancelFirst, err = ex.GetOneOrder(cancelFirst.OrderID2, goex.BTC_USDT)
+	assert.Nil(t, err)
+	assert.Equal(t, goex.ORDER_CANCEL, cancelFirst.Status)
+	assert.Equal(t, 0.0, cancelFirst.DealAmount)
+
+	acc, _ := ex.GetAccount()
+	assert.InDelta(t, 0.0, acc.SubAccounts[goex.USDT].ForzenAmount, 1e-8)
+}
+
+func TestExchangeSim_KLine_OrderLatency(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_KLine)
+	ex.orderLatency = NewFixedLatency(time.Second)
+
+	ord, _ := ex.LimitBuy("0.1", "7000", goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_UNFINISH, ord.Status)
+
+	//订单在下一根K线开盘时到达,以开盘价按taker成交
+	ex.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 6995, High: 7000, Low: 6984, Close: 6990, Vol: 10})
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.Equal(t, 6995.0, ord.AvgPrice)
+	assert.InDelta(t, 0.1*ex.takerFee, ord.Fee, 1e-12)
+}
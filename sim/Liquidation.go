@@ -0,0 +1,198 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"math"
+)
+
+const ORDER_FEATURE_LIQUIDATION = 100 //强平单,FutureOrder.OrderType
+
+//强平记录
+type LiquidationEvent struct {
+	Timestamp         int64   //强平时间(毫秒)
+	Equity            float64 //强平前的账户权益
+	MaintenanceMargin float64 //强平前的维持保证金
+	Fee               float64 //强平手续费
+	Loss              float64 //标记价格比破产价格更差时的穿仓损失,由风险准备金承担
+	OrderIds          []string
+}
+
+//全部持仓的维持保证金
+func (f *FutureExchangeSim) maintenanceMargin() float64 {
+	margin := 0.0
+	for _, pair := range f.engine.supportCurrencyPairs {
+		amount := f.position(pair, goex.OPEN_BUY).amount + f.position(pair, goex.OPEN_SELL).amount
+		margin += amount * f.markPrice(pair) * f.maintenanceMarginRate
+	}
+	return margin
+}
+
+//K线回测时撮合引擎沿价格路径逐点撮合,每个点撮合后按该点的价格检查强平:影线穿过强平价、
+//收盘又收回的K线也会在影线的价格强平,路径上强平之后才触及的挂单和条件单不再成交
+func (f *FutureExchangeSim) checkPathPoint(point int) bool {
+	var unchecked []goex.CurrencyPair
+	for _, pair := range f.engine.supportCurrencyPairs {
+		k := f.engine.market(pair).kline
+		if klineTime(k) > f.checkedKlines[pair.ToSymbol("_")] {
+			unchecked = append(unchecked, pair)
+			f.pathPrices[pair.ToSymbol("_")] = f.engine.klinePricePath(k)[point]
+		}
+	}
+
+	liquidated := f.liquidateIfNeeded()
+
+	for _, pair := range unchecked {
+		delete(f.pathPrices, pair.ToSymbol("_"))
+		if liquidated || point == klinePathPoints-1 {
+			f.checkedKlines[pair.ToSymbol("_")] = klineTime(f.engine.market(pair).kline)
+		}
+	}
+	return liquidated
+}
+
+//全仓模式下账户权益低于维持保证金时强平:撤销全部挂单和条件单,按破产价格平掉全部持仓并收取强平手续费
+func (f *FutureExchangeSim) liquidateIfNeeded() bool {
+	maintenanceMargin := f.maintenanceMargin()
+	if maintenanceMargin <= 0 {
+		return false
+	}
+
+	unrealized, _ := f.positionSummary()
+	equity := f.balance + unrealized
+	if equity >= maintenanceMargin {
+		return false
+	}
+
+	event := LiquidationEvent{
+		Timestamp:         f.engine.now(),
+		Equity:            equity,
+		MaintenanceMargin: maintenanceMargin,
+	}
+
+	for _, ord := range f.engine.sortedPendingOrders() {
+		f.engine.closeOrder(ord, goex.ORDER_CANCEL)
+	}
+	for _, t := range f.engine.sortedTriggerOrders() {
+		f.engine.cancelOrder(t.ord)
+	}
+
+	for _, pair := range f.engine.supportCurrencyPairs {
+		for _, oType := range []int{goex.CLOSE_BUY, goex.CLOSE_SELL} {
+			pos := f.position(pair, oType)
+			if pos.amount == 0 {
+				continue
+			}
+			ord, shortfall := f.liquidate(pair, oType, pos)
+			event.Fee += ord.Fee
+			event.Loss += shortfall
+			event.OrderIds = append(event.OrderIds, ord.OrderID2)
+		}
+	}
+
+	if f.balance < 1e-6 { //按破产价格平仓后钱包余额为0,去掉手续费取整的误差
+		f.balance = 0
+	}
+
+	f.liquidations = append(f.liquidations, event)
+	f.assetSnapshot()
+	return true
+}
+
+//以破产价格强平一个方向的持仓,强平单记入历史订单。标记价格比破产价格更差时返回差额,即穿仓损失
+func (f *FutureExchangeSim) liquidate(pair goex.CurrencyPair, oType int, pos *futurePosition) (*goex.Order, float64) {
+	side, _ := futureSide(oType)
+	long := oType == goex.CLOSE_BUY
+	price := f.bankruptcyPrice(pair, long)
+	ord := &goex.Order{
+		Price:        price,
+		Amount:       pos.amount,
+		AvgPrice:     price,
+		DealAmount:   pos.amount,
+		Fee:          math.Floor(pos.amount*price*f.liquidationFeeRate*100000000) / 100000000,
+		OrderID2:     f.engine.idGen.Get(),
+		OrderTime:    int(f.engine.now()),
+		FinishedTime: f.engine.now(),
+		Status:       goex.ORDER_FINISH,
+		Currency:     pair,
+		Side:         side,
+		Type:         "liquidation",
+		OrderType:    ORDER_FEATURE_LIQUIDATION,
+	}
+	f.orders[ord.OrderID2] = &futureOrderInfo{oType: oType, lever: f.lever(pair), contractType: goex.SWAP_USDT_CONTRACT}
+
+	shortfall := (f.markPrice(pair) - price) * pos.amount
+	if long {
+		shortfall = -shortfall
+	}
+
+	pos.available = pos.amount
+	f.unFrozenAsset(ord.Fee, ord.DealAmount, price, *ord)
+	f.engine.finishedOrders[ord.OrderID2] = ord
+	f.engine.recordFill(ord, price, ord.DealAmount, ord.Fee, true)
+	f.engine.emitFillEvent(OrderEventType_Filled, ord, price, ord.DealAmount, ord.Fee, true)
+
+	return ord, math.Max(shortfall, 0)
+}
+
+//破产价格:其他持仓按标记价格平仓并扣除强平手续费,该持仓在此价格平仓并扣除强平手续费后账户权益为0
+func (f *FutureExchangeSim) bankruptcyPrice(pair goex.CurrencyPair, long bool) float64 {
+	pos := f.position(pair, goex.OPEN_SELL)
+	if long {
+		pos = f.position(pair, goex.OPEN_BUY)
+	}
+
+	other := f.balance
+	for _, p := range f.engine.supportCurrencyPairs {
+		for _, l := range []bool{true, false} {
+			if p.Eq(pair) && l == long {
+				continue
+			}
+			q := f.position(p, goex.OPEN_SELL)
+			if l {
+				q = f.position(p, goex.OPEN_BUY)
+			}
+			other += f.unrealizedProfit(p, l) - q.amount*f.markPrice(p)*f.liquidationFeeRate
+		}
+	}
+
+	var price float64
+	if long {
+		price = (pos.avgPrice*pos.amount - other) / (pos.amount * (1 - f.liquidationFeeRate))
+	} else {
+		price = (other + pos.avgPrice*pos.amount) / (pos.amount * (1 + f.liquidationFeeRate))
+	}
+	return math.Max(price, 0)
+}
+
+//预估强平价格,假设其他持仓盈亏不变
+func (f *FutureExchangeSim) forceLiquidationPrice(pair goex.CurrencyPair, long bool) float64 {
+	pos := f.position(pair, goex.OPEN_SELL)
+	if long {
+		pos = f.position(pair, goex.OPEN_BUY)
+	}
+	if pos.amount == 0 {
+		return 0
+	}
+
+	unrealized, _ := f.positionSummary()
+	other := f.balance + unrealized - f.unrealizedProfit(pair, long) -
+		(f.maintenanceMargin() - pos.amount*f.markPrice(pair)*f.maintenanceMarginRate)
+
+	var price float64
+	if long {
+		price = (pos.avgPrice*pos.amount - other) / (pos.amount * (1 - f.maintenanceMarginRate))
+	} else {
+		price = (other + pos.avgPrice*pos.amount) / (pos.amount * (1 + f.maintenanceMarginRate))
+	}
+	return math.Max(price, 0)
+}
+
+//强平历史
+func (f *FutureExchangeSim) GetLiquidations() []LiquidationEvent {
+	f.engine.RLock()
+	defer f.engine.RUnlock()
+
+	liquidations := make([]LiquidationEvent, len(f.liquidations))
+	copy(liquidations, f.liquidations)
+	return liquidations
+}
@@ -0,0 +1,94 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"math"
+)
+
+const interestPeriod = int64(3600 * 1000) //按小时计息
+
+//按计价币估值的净资产和负债,有持仓或负债的币种无法估值时ok为false
+func (ex *ExchangeSim) netAssetAndLiabilities() (netAsset, liabilities float64, ok bool) {
+	for _, sub := range ex.acc.SubAccounts {
+		price, priced := ex.valuationPrice(sub.Currency)
+		if !priced && sub.Amount+sub.ForzenAmount+sub.LoanAmount != 0 {
+			return 0, 0, false
+		}
+		netAsset += (sub.Amount + sub.ForzenAmount - sub.LoanAmount) * price
+		liabilities += sub.LoanAmount * price
+	}
+	return netAsset, liabilities, true
+}
+
+//杠杆模式下可用资产不足need时自动借入差额,负债不能超过净资产的(杠杆倍数-1)倍
+func (ex *ExchangeSim) autoBorrow(currency goex.Currency, need float64) error {
+	if !ex.marginTrade {
+		return nil
+	}
+
+	sub := ex.acc.SubAccounts[currency]
+	borrow := need - sub.Amount
+	if borrow <= 0 {
+		return nil
+	}
+
+	netAsset, liabilities, ok := ex.netAssetAndLiabilities()
+	if !ok {
+		return InsufficientError //无法估值的资产不能作为借币的担保
+	}
+	price, ok := ex.valuationPrice(currency)
+	if !ok || liabilities+borrow*price > netAsset*(ex.marginLeverage-1) {
+		return InsufficientError
+	}
+
+	sub.Currency = currency
+	sub.Amount += borrow
+	sub.LoanAmount += borrow
+	ex.acc.SubAccounts[currency] = sub
+
+	return nil
+}
+
+//用可用资产自动归还借币
+func (ex *ExchangeSim) autoRepay(currency goex.Currency) {
+	if !ex.marginTrade {
+		return
+	}
+
+	sub := ex.acc.SubAccounts[currency]
+	repay := math.Min(sub.Amount, sub.LoanAmount)
+	if repay <= 0 {
+		return
+	}
+
+	sub.Amount -= repay
+	sub.LoanAmount -= repay
+	ex.acc.SubAccounts[currency] = sub
+}
+
+//每到整点按小时利率计息,利息计入负债
+func (ex *ExchangeSim) accrueInterest() {
+	if !ex.marginTrade || ex.marginInterestRate <= 0 {
+		return
+	}
+
+	now := ex.now()
+	if ex.nextInterestTime == 0 {
+		ex.nextInterestTime = now - now%interestPeriod + interestPeriod
+		return
+	}
+
+	for ; ex.nextInterestTime <= now; ex.nextInterestTime += interestPeriod {
+		for currency, sub := range ex.acc.SubAccounts {
+			if sub.LoanAmount <= 0 {
+				continue
+			}
+			sub.LoanAmount += sub.LoanAmount * ex.marginInterestRate
+			ex.acc.SubAccounts[currency] = sub
+		}
+	}
+
+	for currency := range ex.acc.SubAccounts {
+		ex.autoRepay(currency)
+	}
+}
@@ -0,0 +1,75 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestExchangeSim_MarginShortSell(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_KLine)
+	ex.marginTrade = true
+	ex.marginInterestRate = 0.0001
+
+	ord, err := ex.LimitSell("3", "7000", goex.BTC_USDT)
+	assert.Nil(t, err)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+
+	acc, _ := ex.GetAccount()
+	assert.Equal(t, 2.0, acc.SubAccounts[goex.BTC].LoanAmount)
+	assert.Equal(t, 0.0, acc.SubAccounts[goex.BTC].Amount)
+
+	netAsset, liabilities, ok := ex.netAssetAndLiabilities()
+	assert.True(t, ok)
+	assert.InDelta(t, 14000.0, liabilities, 1e-8)
+	assert.InDelta(t, 107000-3*7000*0.0002, netAsset, 1e-6)
+
+	//整点计息,利息计入负债
+	ex.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 7000, High: 7000, Low: 7000, Close: 7000, Vol: 10})
+	ex.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971200 + 7200, Open: 6900, High: 6900, Low: 6900, Close: 6900, Vol: 10})
+	acc, _ = ex.GetAccount()
+	assert.InDelta(t, 2*math.Pow(1.0001, 2), acc.SubAccounts[goex.BTC].LoanAmount, 1e-12)
+
+	//买回后自动还币
+	ord, err = ex.LimitBuy("3", "6900", goex.BTC_USDT)
+	assert.Nil(t, err)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+
+	acc, _ = ex.GetAccount()
+	assert.Equal(t, 0.0, acc.SubAccounts[goex.BTC].LoanAmount)
+	assert.InDelta(t, 3*0.9998-2*math.Pow(1.0001, 2), acc.SubAccounts[goex.BTC].Amount, 1e-9)
+}
+
+func TestExchangeSim_MarginBorrowLimit(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_KLine)
+
+	_, err := ex.LimitBuy("20", "6900", goex.BTC_USDT)
+	assert.Equal(t, InsufficientError, err)
+
+	ex.marginTrade = true
+	ex.marginLeverage = 3
+
+	//净资产107000,最多借入214000
+	_, err = ex.LimitBuy("50", "6900", goex.BTC_USDT)
+	assert.Equal(t, InsufficientError, err)
+
+	ord, err := ex.LimitBuy("40", "6900", goex.BTC_USDT)
+	assert.Nil(t, err)
+	acc, _ := ex.GetAccount()
+	assert.InDelta(t, 176000.0, acc.SubAccounts[goex.USDT].LoanAmount, 1e-8)
+
+	//撤单后归还借币
+	ex.CancelOrder(ord.OrderID2, goex.BTC_USDT)
+	acc, _ = ex.GetAccount()
+	assert.Equal(t, 0.0, acc.SubAccounts[goex.USDT].LoanAmount)
+	assert.InDelta(t, 100000.0, acc.SubAccounts[goex.USDT].Amount, 1e-8)
+
+	//持有无法估值的币种时拒绝借币
+	ex.acc.SubAccounts[goex.ETH] = goex.SubAccount{Currency: goex.ETH, Amount: 1000}
+	_, err = ex.LimitBuy("20", "6900", goex.BTC_USDT)
+	assert.Equal(t, InsufficientError, err)
+	acc, _ = ex.GetAccount()
+	assert.Equal(t, 0.0, acc.SubAccounts[goex.USDT].LoanAmount)
+}
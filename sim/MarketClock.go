@@ -0,0 +1,165 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/loader"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//单个币对的行情状态,挂单按各自币对的行情撮合
+type marketState struct {
+	depth            goex.Depth
+	kline            goex.Kline
+	prevKline        goex.Kline
+	klineConsumedVol float64 //当前K线已被模拟成交消耗的成交量
+	klineQuotePrice  float64 //K线内部taker单的成交参考价,为0时使用收盘价
+	liquidity        *depthLiquidity
+	volatility       *priceVolatility
+}
+
+func (ex *ExchangeSim) market(pair goex.CurrencyPair) *marketState {
+	key := pair.ToSymbol("_")
+	m := ex.markets[key]
+	if m == nil {
+		m = &marketState{
+			liquidity:  newDepthLiquidity(),
+			volatility: newPriceVolatility(ex.volatilityWindow),
+		}
+		ex.markets[key] = m
+	}
+	return m
+}
+
+//行情时钟:按时间戳合并所有币对的深度数据,各币对的行情按时间顺序推进,不会因为策略只读取某个币对而错位;
+//跨交易所回测时多个ExchangeSim共用同一个时钟(见Coordinator)
+type marketClock struct {
+	sync.Mutex
+	streams     []*depthStream
+	now         int64          //最新行情事件的时间戳(毫秒)
+	beforeEvent func(ts int64) //每个行情事件推送之前调用,用于处理到账的划转
+	afterEvent  func(ts int64) //每个行情事件撮合之后调用,用于记录合计净值
+}
+
+type depthStream struct {
+	sim    *ExchangeSim
+	pair   goex.CurrencyPair
+	loader *loader.DepthDataLoader
+	head   *goex.Depth //下一个还未推送的深度快照
+	done   bool
+}
+
+func (s *depthStream) peek() *goex.Depth {
+	if s.head == nil && !s.done {
+		depth := s.loader.Next()
+		if depth == nil {
+			s.done = true
+			return nil
+		}
+		d := *depth //loader加载下一天数据时会复用底层数组
+		s.head = &d
+	}
+	return s.head
+}
+
+func (c *marketClock) add(sim *ExchangeSim, pair goex.CurrencyPair, depthLoader *loader.DepthDataLoader) {
+	c.streams = append(c.streams, &depthStream{sim: sim, pair: pair, loader: depthLoader})
+}
+
+func (c *marketClock) stream(sim *ExchangeSim, pair goex.CurrencyPair) *depthStream {
+	for _, s := range c.streams {
+		if s.sim == sim && s.pair.Eq(pair) {
+			return s
+		}
+	}
+	return nil
+}
+
+//推进时钟直到sim的pair的下一个深度快照,期间时间不晚于它的其它快照按时间顺序推送,
+//同一时间戳的快照按添加顺序推送,pair的数据已经结束时返回nil
+func (c *marketClock) advance(sim *ExchangeSim, pair goex.CurrencyPair) *goex.Depth {
+	var pushed []*ExchangeSim
+	defer func() { flushPushed(pushed) }()
+	c.Lock()
+	defer c.Unlock()
+
+	target := c.stream(sim, pair)
+	if target == nil {
+		return nil
+	}
+
+	for target.peek() != nil {
+		next := c.earliest()
+		depth := c.push(next)
+		pushed = appendSim(pushed, next.sim)
+		if next == target {
+			return &depth
+		}
+	}
+
+	return nil
+}
+
+//推送时间最早的一个深度快照,所有数据都已结束时返回nil
+func (c *marketClock) next() (*ExchangeSim, *goex.Depth) {
+	var pushed []*ExchangeSim
+	defer func() { flushPushed(pushed) }()
+	c.Lock()
+	defer c.Unlock()
+
+	next := c.earliest()
+	if next == nil {
+		return nil, nil
+	}
+
+	depth := c.push(next)
+	pushed = appendSim(pushed, next.sim)
+	return next.sim, &depth
+}
+
+//下一个快照时间最早的数据流,时间相同时取先添加的
+func (c *marketClock) earliest() *depthStream {
+	var next *depthStream
+	for _, s := range c.streams {
+		if s.peek() == nil {
+			continue
+		}
+		if next == nil || s.head.UTime.Before(next.head.UTime) {
+			next = s
+		}
+	}
+	return next
+}
+
+//推送快照并撮合,订单事件由调用方在释放时钟锁之后推送
+func (c *marketClock) push(s *depthStream) goex.Depth {
+	depth := *s.head
+	s.head = nil
+	now := depth.UTime.UnixNano() / int64(time.Millisecond)
+	atomic.StoreInt64(&c.now, now)
+	if c.beforeEvent != nil {
+		c.beforeEvent(now)
+	}
+	s.sim.applyDepth(depth)
+	if c.afterEvent != nil {
+		c.afterEvent(now)
+	}
+	return depth
+}
+
+func appendSim(sims []*ExchangeSim, sim *ExchangeSim) []*ExchangeSim {
+	for _, s := range sims {
+		if s == sim {
+			return sims
+		}
+	}
+	return append(sims, sim)
+}
+
+//回调中可能再读取行情推进时钟,必须在不持有时钟锁时推送
+func flushPushed(sims []*ExchangeSim) {
+	for _, sim := range sims {
+		sim.flushOrderEvents()
+	}
+}
@@ -0,0 +1,161 @@
+package sim
+
+import (
+	"fmt"
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestExchangeSim_MultiPairClock(t *testing.T) {
+	os.MkdirAll("data", 0755)
+	defer os.Remove("data")
+	files := map[string]string{
+		"btcusdt": "1583971200000,7001,1,7000,1\n1583971202000,7011,1,7010,1\n1583971204000,7021,1,7020,1\n",
+		"ethusdt": "1583971201000,201,1,200,1\n1583971203000,190,1,189,1\n",
+	}
+	for symbol, data := range files {
+		fileName := fmt.Sprintf("data/clock.test_%s_2020-03-12.csv", symbol)
+		ioutil.WriteFile(fileName, []byte(data), 0644)
+		defer os.Remove(fileName)
+	}
+
+	ex := newExchangeSim(model.ExchangeSimConfig{
+		ExName:               "clock.test",
+		QuoteCurrency:        goex.USDT,
+		SupportCurrencyPairs: []goex.CurrencyPair{goex.BTC_USDT, goex.ETH_USDT},
+		Account: goex.Account{
+			SubAccounts: map[goex.Currency]goex.SubAccount{
+				goex.BTC:  {Currency: goex.BTC},
+				goex.ETH:  {Currency: goex.ETH},
+				goex.USDT: {Currency: goex.USDT, Amount: 10000},
+			},
+		},
+		BackTestStartTime: time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC),
+		BackTestEndTime:   time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC),
+		BackTestData:      model.BackTestDataType_Depth,
+		DepthSize:         1,
+	})
+
+	depth, err := ex.GetDepth(1, goex.BTC_USDT)
+	assert.Nil(t, err)
+	assert.Equal(t, 7000.0, depth.BidList[0].Price)
+	_, err = ex.GetTicker(goex.ETH_USDT)
+	assert.Equal(t, NoMarketPriceError, err)
+
+	//只读取BTC的深度,更早的ETH快照也按时间顺序推送
+	depth, _ = ex.GetDepth(1, goex.BTC_USDT)
+	assert.Equal(t, 7010.0, depth.BidList[0].Price)
+	ticker, _ := ex.GetTicker(goex.ETH_USDT)
+	assert.Equal(t, 200.0, ticker.Buy)
+	ticker, _ = ex.GetTicker(goex.BTC_USDT)
+	assert.Equal(t, 7010.0, ticker.Buy)
+
+	//ETH的挂单在ETH行情到来时按ETH的深度撮合
+	ord, _ := ex.LimitBuy("1", "195", goex.ETH_USDT)
+	assert.Equal(t, goex.ORDER_UNFINISH, ord.Status)
+	ex.GetDepth(1, goex.BTC_USDT)
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.ETH_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.Equal(t, 190.0, ord.AvgPrice)
+	assert.Equal(t, int64(1583971203000), ord.FinishedTime)
+
+	_, err = ex.GetDepth(1, goex.ETH_USDT)
+	assert.Equal(t, DataFinishedError, err)
+}
+
+func TestExchangeSim_ClockCallbackGetDepth(t *testing.T) {
+	os.MkdirAll("data", 0755)
+	defer os.Remove("data")
+	fileName := "data/clock.callback_btcusdt_2020-03-12.csv"
+	ioutil.WriteFile(fileName, []byte("1583971200000,7001,1,7000,1\n1583971201000,6991,1,6990,1\n1583971202000,6981,1,6980,1\n"), 0644)
+	defer os.Remove(fileName)
+
+	ex := newExchangeSim(model.ExchangeSimConfig{
+		ExName:               "clock.callback",
+		QuoteCurrency:        goex.USDT,
+		SupportCurrencyPairs: []goex.CurrencyPair{goex.BTC_USDT},
+		Account: goex.Account{
+			SubAccounts: map[goex.Currency]goex.SubAccount{
+				goex.BTC:  {Currency: goex.BTC},
+				goex.USDT: {Currency: goex.USDT, Amount: 10000},
+			},
+		},
+		BackTestStartTime: time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC),
+		BackTestEndTime:   time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC),
+		BackTestData:      model.BackTestDataType_Depth,
+		DepthSize:         1,
+	})
+
+	//回调中继续读取行情不能死锁
+	var callbackDepth *goex.Depth
+	ex.OrderCallback(func(order *goex.Order) {
+		if order.Status == goex.ORDER_FINISH {
+			callbackDepth, _ = ex.GetDepth(1, goex.BTC_USDT)
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ex.GetDepth(1, goex.BTC_USDT)
+		ex.LimitBuy("1", "6995", goex.BTC_USDT)
+		ex.GetDepth(1, goex.BTC_USDT)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetDepth in the order callback deadlocked")
+	}
+	assert.NotNil(t, callbackDepth)
+	assert.Equal(t, 6980.0, callbackDepth.BidList[0].Price)
+}
+
+func TestExchangeSim_ClockTieBreak(t *testing.T) {
+	os.MkdirAll("data", 0755)
+	defer os.Remove("data")
+	files := map[string]string{
+		"btcusdt": "1583971200000,7001,1,7000,1\n1583971201000,7011,1,7010,1\n",
+		"ethusdt": "1583971200000,201,1,200,1\n1583971201000,211,1,210,1\n",
+	}
+	for symbol, data := range files {
+		fileName := fmt.Sprintf("data/clock.tie_%s_2020-03-12.csv", symbol)
+		ioutil.WriteFile(fileName, []byte(data), 0644)
+		defer os.Remove(fileName)
+	}
+
+	ex := newExchangeSim(model.ExchangeSimConfig{
+		ExName:               "clock.tie",
+		QuoteCurrency:        goex.USDT,
+		SupportCurrencyPairs: []goex.CurrencyPair{goex.BTC_USDT, goex.ETH_USDT},
+		Account: goex.Account{
+			SubAccounts: map[goex.Currency]goex.SubAccount{
+				goex.USDT: {Currency: goex.USDT, Amount: 10000},
+			},
+		},
+		BackTestStartTime: time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC),
+		BackTestEndTime:   time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC),
+		BackTestData:      model.BackTestDataType_Depth,
+		DepthSize:         1,
+	})
+
+	//同一时间戳按添加顺序推送,先添加的BTC不会被ETH抢先
+	ex.GetDepth(1, goex.BTC_USDT)
+	_, err := ex.GetTicker(goex.ETH_USDT)
+	assert.Equal(t, NoMarketPriceError, err)
+
+	depth, _ := ex.NextDepth()
+	assert.True(t, depth.Pair.Eq(goex.ETH_USDT))
+	assert.Equal(t, 200.0, depth.BidList[0].Price)
+
+	//读取后添加的ETH时,同一时间戳的BTC先推送
+	depth, _ = ex.GetDepth(1, goex.ETH_USDT)
+	assert.Equal(t, 210.0, depth.BidList[0].Price)
+	ticker, _ := ex.GetTicker(goex.BTC_USDT)
+	assert.Equal(t, 7010.0, ticker.Buy)
+}
@@ -0,0 +1,322 @@
+package sim
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+//净值曲线每分钟记录一个点
+const equityInterval = int64(time.Minute / time.Millisecond)
+
+const yearMillis = float64(365 * 24 * time.Hour / time.Millisecond)
+
+//净值曲线上的一个点
+type EquityPoint struct {
+	Timestamp int64 //毫秒
+	NetAsset  float64
+}
+
+//回测的收益风险指标,比例均为小数,无风险利率按0计算
+type Metrics struct {
+	ExName              string
+	StartTime           int64 //毫秒
+	EndTime             int64
+	InitialNetAsset     float64
+	FinalNetAsset       float64
+	TotalReturn         float64
+	AnnualReturn        float64
+	Volatility          float64 //年化波动率
+	Sharpe              float64
+	Sortino             float64
+	Calmar              float64
+	MaxDrawdown         float64
+	MaxDrawdownStart    int64 //回撤开始(净值高点)的时间
+	MaxDrawdownBottom   int64 //净值最低点的时间
+	MaxDrawdownRecover  int64 //净值回到高点的时间,未恢复为0
+	MaxDrawdownDuration time.Duration
+	Trades              int //开平仓配对的交易笔数
+	WinRate             float64
+	ProfitFactor        float64 //总盈利/总亏损
+	Expectancy          float64 //每笔交易的平均盈亏,盈亏类指标都按平仓时的价格折算成净值币种
+	AvgWin              float64
+	AvgLoss             float64 //正数
+	LargestWin          float64
+	LargestLoss         float64 //正数
+	AvgMAE              float64
+	AvgMFE              float64
+	AvgHoldingTime      time.Duration
+	Exposure            float64 //有持仓的时间占比
+	Turnover            float64 //成交额/平均净值
+}
+
+//行情更新撮合后记录净值
+func (ex *ExchangeSim) recordEquity() {
+	if ex.currTime <= 0 {
+		return
+	}
+	ex.equity = appendEquity(ex.equity, EquityPoint{Timestamp: ex.currTime, NetAsset: ex.ledger.netAsset()})
+}
+
+//每分钟保留最后一次事件的净值,第一个点作为起始净值保留不动
+func appendEquity(equity []EquityPoint, point EquityPoint) []EquityPoint {
+	if n := len(equity); n > 1 && equity[n-1].Timestamp/equityInterval == point.Timestamp/equityInterval {
+		equity[n-1] = point
+		return equity
+	}
+	return append(equity, point)
+}
+
+//净值曲线,最后一个点为当前净值
+func (ex *ExchangeSim) EquityCurve() []EquityPoint {
+	ex.RLock()
+	defer ex.RUnlock()
+	return ex.equityCurve()
+}
+
+func (ex *ExchangeSim) equityCurve() []EquityPoint {
+	equity := make([]EquityPoint, len(ex.equity), len(ex.equity)+1)
+	copy(equity, ex.equity)
+	if n := len(equity); n > 0 && equity[n-1].Timestamp < ex.currTime {
+		equity = append(equity, EquityPoint{Timestamp: ex.currTime, NetAsset: ex.ledger.netAsset()})
+	}
+	return equity
+}
+
+func (ex *ExchangeSim) Metrics() *Metrics {
+	ex.RLock()
+	defer ex.RUnlock()
+
+	trips := make([]RoundTrip, len(ex.tracker.roundTrips))
+	copy(trips, ex.tracker.roundTrips)
+	return calcMetrics(ex.name, ex.equityCurve(), trips, ex.tracker.heldTime(ex.currTime), ex.tracker.notional)
+}
+
+func (f *FutureExchangeSim) Metrics() *Metrics {
+	return f.engine.Metrics()
+}
+
+//held为有持仓的累计时间(毫秒),notional为折算成净值币种的累计成交额
+func calcMetrics(exName string, equity []EquityPoint, trips []RoundTrip, held int64, notional float64) *Metrics {
+	m := &Metrics{ExName: exName}
+	if len(equity) == 0 {
+		return m
+	}
+
+	first, last := equity[0], equity[len(equity)-1]
+	m.StartTime, m.EndTime = first.Timestamp, last.Timestamp
+	m.InitialNetAsset, m.FinalNetAsset = first.NetAsset, last.NetAsset
+	duration := float64(m.EndTime - m.StartTime)
+	if m.InitialNetAsset > 0 {
+		m.TotalReturn = m.FinalNetAsset/m.InitialNetAsset - 1
+		if duration > 0 && m.FinalNetAsset > 0 {
+			m.AnnualReturn = math.Pow(m.FinalNetAsset/m.InitialNetAsset, yearMillis/duration) - 1
+		}
+	}
+
+	m.calcRisk(equity)
+	m.calcDrawdown(equity)
+	if m.MaxDrawdown > 0 {
+		m.Calmar = m.AnnualReturn / m.MaxDrawdown
+	}
+	m.calcTrades(equity, trips, held, notional)
+
+	return m
+}
+
+//按净值曲线的收益率序列计算年化波动率、夏普和索提诺
+func (m *Metrics) calcRisk(equity []EquityPoint) {
+	var (
+		returns   []float64
+		intervals []int64
+	)
+	for i := 1; i < len(equity); i++ {
+		if equity[i-1].NetAsset <= 0 {
+			continue
+		}
+		returns = append(returns, equity[i].NetAsset/equity[i-1].NetAsset-1)
+		intervals = append(intervals, equity[i].Timestamp-equity[i-1].Timestamp)
+	}
+	if len(returns) < 2 {
+		return
+	}
+
+	//收益率的周期取时间间隔的中位数,最后一个点可能不足一个周期
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	periods := yearMillis / float64(intervals[len(intervals)/2])
+
+	var mean, variance, downside float64
+	for _, r := range returns {
+		mean += r
+	}
+	mean /= float64(len(returns))
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+		if r < 0 {
+			downside += r * r
+		}
+	}
+	std := math.Sqrt(variance / float64(len(returns)-1))
+	downsideDev := math.Sqrt(downside / float64(len(returns)))
+
+	m.Volatility = std * math.Sqrt(periods)
+	if std > 0 {
+		m.Sharpe = mean / std * math.Sqrt(periods)
+	}
+	if downsideDev > 0 {
+		m.Sortino = mean / downsideDev * math.Sqrt(periods)
+	}
+}
+
+//回撤从净值高点算起,恢复时间为净值重新回到该高点的时间
+func (m *Metrics) calcDrawdown(equity []EquityPoint) {
+	peak := equity[0]
+	for _, p := range equity {
+		if p.NetAsset >= peak.NetAsset {
+			if m.MaxDrawdown > 0 && m.MaxDrawdownRecover == 0 && m.MaxDrawdownStart == peak.Timestamp {
+				m.MaxDrawdownRecover = p.Timestamp
+			}
+			peak = p
+			continue
+		}
+		if peak.NetAsset <= 0 {
+			continue
+		}
+
+		if dd := 1 - p.NetAsset/peak.NetAsset; dd > m.MaxDrawdown {
+			m.MaxDrawdown = dd
+			m.MaxDrawdownStart = peak.Timestamp
+			m.MaxDrawdownBottom = p.Timestamp
+			m.MaxDrawdownRecover = 0
+		}
+	}
+
+	if m.MaxDrawdown <= 0 {
+		return
+	}
+	end := m.EndTime
+	if m.MaxDrawdownRecover > 0 {
+		end = m.MaxDrawdownRecover
+	}
+	m.MaxDrawdownDuration = time.Duration(end-m.MaxDrawdownStart) * time.Millisecond
+}
+
+//按开平仓配对的交易统计胜率、盈亏分布,持仓时间占比和换手率
+func (m *Metrics) calcTrades(equity []EquityPoint, trips []RoundTrip, held int64, notional float64) {
+	var (
+		wins         int
+		profit, loss float64
+		holding      int64
+		mae, mfe     float64
+	)
+	for _, t := range trips {
+		pnl := t.PnL * t.QuoteRate
+		if pnl > 0 {
+			wins++
+			profit += pnl
+			m.LargestWin = math.Max(m.LargestWin, pnl)
+		} else {
+			loss -= pnl
+			m.LargestLoss = math.Max(m.LargestLoss, -pnl)
+		}
+		holding += t.CloseTime - t.OpenTime
+		mae += t.MAE * t.QuoteRate
+		mfe += t.MFE * t.QuoteRate
+	}
+
+	m.Trades = len(trips)
+	if m.Trades > 0 {
+		m.WinRate = float64(wins) / float64(m.Trades)
+		m.Expectancy = (profit - loss) / float64(m.Trades)
+		if wins > 0 {
+			m.AvgWin = profit / float64(wins)
+		}
+		if wins < m.Trades {
+			m.AvgLoss = loss / float64(m.Trades-wins)
+		}
+		m.AvgMAE = mae / float64(m.Trades)
+		m.AvgMFE = mfe / float64(m.Trades)
+		m.AvgHoldingTime = time.Duration(holding/int64(m.Trades)) * time.Millisecond
+		if loss > 0 {
+			m.ProfitFactor = profit / loss
+		} else if profit > 0 {
+			m.ProfitFactor = math.Inf(1)
+		}
+	}
+	if duration := m.EndTime - m.StartTime; duration > 0 {
+		m.Exposure = math.Min(float64(held)/float64(duration), 1)
+	}
+
+	var avgNetAsset float64
+	for _, p := range equity {
+		avgNetAsset += p.NetAsset
+	}
+	avgNetAsset /= float64(len(equity))
+	if avgNetAsset > 0 {
+		m.Turnover = notional / avgNetAsset
+	}
+}
+
+func formatTime(ts int64) string {
+	if ts <= 0 {
+		return "-"
+	}
+	return time.Unix(ts/1000, 0).UTC().Format("2006-01-02 15:04")
+}
+
+//指标汇总表,每个模拟交易所一列
+func metricsTable(metrics []*Metrics) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	rows := []struct {
+		name  string
+		value func(m *Metrics) string
+	}{
+		{"", func(m *Metrics) string { return m.ExName }},
+		{"开始", func(m *Metrics) string { return formatTime(m.StartTime) }},
+		{"结束", func(m *Metrics) string { return formatTime(m.EndTime) }},
+		{"初始净值", func(m *Metrics) string { return fmt.Sprintf("%.4f", m.InitialNetAsset) }},
+		{"最终净值", func(m *Metrics) string { return fmt.Sprintf("%.4f", m.FinalNetAsset) }},
+		{"总收益率", func(m *Metrics) string { return percent(m.TotalReturn) }},
+		{"年化收益率", func(m *Metrics) string { return percent(m.AnnualReturn) }},
+		{"年化波动率", func(m *Metrics) string { return percent(m.Volatility) }},
+		{"夏普", func(m *Metrics) string { return fmt.Sprintf("%.4f", m.Sharpe) }},
+		{"索提诺", func(m *Metrics) string { return fmt.Sprintf("%.4f", m.Sortino) }},
+		{"卡玛", func(m *Metrics) string { return fmt.Sprintf("%.4f", m.Calmar) }},
+		{"最大回撤", func(m *Metrics) string { return percent(m.MaxDrawdown) }},
+		{"回撤开始", func(m *Metrics) string { return formatTime(m.MaxDrawdownStart) }},
+		{"回撤最低点", func(m *Metrics) string { return formatTime(m.MaxDrawdownBottom) }},
+		{"回撤恢复", func(m *Metrics) string { return formatTime(m.MaxDrawdownRecover) }},
+		{"回撤持续", func(m *Metrics) string { return m.MaxDrawdownDuration.String() }},
+		{"交易次数", func(m *Metrics) string { return fmt.Sprint(m.Trades) }},
+		{"胜率", func(m *Metrics) string { return percent(m.WinRate) }},
+		{"盈亏比", func(m *Metrics) string { return fmt.Sprintf("%.4f", m.ProfitFactor) }},
+		{"期望", func(m *Metrics) string { return fmt.Sprintf("%.4f", m.Expectancy) }},
+		{"平均盈利", func(m *Metrics) string { return fmt.Sprintf("%.4f", m.AvgWin) }},
+		{"平均亏损", func(m *Metrics) string { return fmt.Sprintf("%.4f", m.AvgLoss) }},
+		{"最大盈利", func(m *Metrics) string { return fmt.Sprintf("%.4f", m.LargestWin) }},
+		{"最大亏损", func(m *Metrics) string { return fmt.Sprintf("%.4f", m.LargestLoss) }},
+		{"平均MAE", func(m *Metrics) string { return fmt.Sprintf("%.4f", m.AvgMAE) }},
+		{"平均MFE", func(m *Metrics) string { return fmt.Sprintf("%.4f", m.AvgMFE) }},
+		{"平均持仓", func(m *Metrics) string { return m.AvgHoldingTime.String() }},
+		{"持仓时间占比", func(m *Metrics) string { return percent(m.Exposure) }},
+		{"换手率", func(m *Metrics) string { return fmt.Sprintf("%.4f", m.Turnover) }},
+	}
+	for _, row := range rows {
+		fmt.Fprint(w, row.name)
+		for _, m := range metrics {
+			fmt.Fprint(w, "\t", row.value(m))
+		}
+		fmt.Fprintln(w, "\t")
+	}
+	w.Flush()
+	return buf.String()
+}
+
+func percent(v float64) string {
+	return fmt.Sprintf("%.2f%%", v*100)
+}
@@ -0,0 +1,97 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCalcMetrics(t *testing.T) {
+	minute := equityInterval
+	equity := []EquityPoint{
+		{Timestamp: 0 * minute, NetAsset: 100},
+		{Timestamp: 1 * minute, NetAsset: 110},
+		{Timestamp: 2 * minute, NetAsset: 99},
+		{Timestamp: 3 * minute, NetAsset: 121},
+		{Timestamp: 4 * minute, NetAsset: 121},
+	}
+	fill := func(ts int64, side goex.TradeSide, price, amount float64) Fill {
+		return Fill{Pair: goex.BTC_USDT, Side: side, Price: price, Amount: amount, FeeCurrency: goex.USDT, Timestamp: ts}
+	}
+	fills := []Fill{
+		fill(0, goex.BUY, 100, 1),
+		fill(minute, goex.SELL, 110, 1),
+		fill(2*minute, goex.BUY, 100, 1),
+		fill(3*minute, goex.SELL, 90, 2), //平多并开空
+	}
+
+	tracker := newPositionTracker(model.CostMethodType_FIFO)
+	for _, f := range fills {
+		tracker.onFill(f)
+	}
+
+	m := calcMetrics("test", equity, tracker.roundTrips, tracker.heldTime(4*minute), tracker.notional)
+	assert.InDelta(t, 0.21, m.TotalReturn, 1e-12)
+	assert.True(t, m.AnnualReturn > m.TotalReturn)
+	assert.True(t, m.Sharpe > 0)
+	assert.True(t, m.Sortino > m.Sharpe)
+
+	assert.InDelta(t, 0.1, m.MaxDrawdown, 1e-12)
+	assert.Equal(t, minute, m.MaxDrawdownStart)
+	assert.Equal(t, 2*minute, m.MaxDrawdownBottom)
+	assert.Equal(t, 3*minute, m.MaxDrawdownRecover)
+	assert.Equal(t, 2*time.Minute, m.MaxDrawdownDuration)
+
+	assert.Equal(t, 2, m.Trades)
+	assert.Equal(t, 0.5, m.WinRate)
+	assert.InDelta(t, 1, m.ProfitFactor, 1e-12)
+	assert.InDelta(t, 0, m.Expectancy, 1e-12)
+	assert.Equal(t, time.Minute, m.AvgHoldingTime)
+	assert.InDelta(t, 0.75, m.Exposure, 1e-12)
+	assert.InDelta(t, 490/110.2, m.Turnover, 1e-12)
+}
+
+func TestExchangeSim_MetricsMixedQuote(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_KLine)
+	ex.supportCurrencyPairs = []goex.CurrencyPair{goex.BTC_USDT, goex.ETH_BTC}
+	ex.takerFee, ex.makerFee, ex.marketOrderSlippage = 0, 0, 0
+	ex.acc.SubAccounts[goex.ETH] = goex.SubAccount{Currency: goex.ETH}
+
+	ex.updateKline(goex.Kline{Pair: goex.ETH_BTC, Timestamp: 1583971200, Open: 0.02, High: 0.02, Low: 0.02, Close: 0.02, Vol: 1000})
+	ex.MarketBuy("0.2", "", goex.ETH_BTC)
+	ex.MarketBuy("7000", "", goex.BTC_USDT)
+
+	ex.updateKline(goex.Kline{Pair: goex.ETH_BTC, Timestamp: 1583971260, Open: 0.021, High: 0.021, Low: 0.021, Close: 0.021, Vol: 1000})
+	ex.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 7100, High: 7100, Low: 7100, Close: 7100, Vol: 10})
+	ex.MarketSell("10", "", goex.ETH_BTC)
+	ex.MarketSell("1", "", goex.BTC_USDT)
+
+	//ETH_BTC的盈亏0.01BTC按平仓时的7100折算成USDT后再和BTC_USDT的盈亏汇总
+	trips := ex.GetRoundTrips(goex.ETH_BTC)
+	assert.Len(t, trips, 1)
+	assert.InDelta(t, 0.01, trips[0].PnL, 1e-9)
+	assert.Equal(t, 7100.0, trips[0].QuoteRate)
+
+	m := ex.Metrics()
+	assert.Equal(t, 2, m.Trades)
+	assert.InDelta(t, 100, m.LargestWin, 1e-6)
+	assert.InDelta(t, (71+100)/2.0, m.Expectancy, 1e-6)
+	assert.InDelta(t, 0.2*7000+7000+0.21*7100+7100, ex.tracker.notional, 1e-6)
+}
+
+func TestAppendEquity(t *testing.T) {
+	minute := equityInterval
+	var equity []EquityPoint
+	equity = appendEquity(equity, EquityPoint{Timestamp: 0, NetAsset: 100})
+	equity = appendEquity(equity, EquityPoint{Timestamp: 10, NetAsset: 101})
+	equity = appendEquity(equity, EquityPoint{Timestamp: 20, NetAsset: 102})
+	equity = appendEquity(equity, EquityPoint{Timestamp: minute, NetAsset: 103})
+	equity = appendEquity(equity, EquityPoint{Timestamp: minute + 30, NetAsset: 99})
+
+	//起始点保留,每分钟取最后一次事件的净值
+	assert.Equal(t, []EquityPoint{{0, 100}, {20, 102}, {minute + 30, 99}}, equity)
+
+	assert.Equal(t, "2020-03-12 00:00", formatTime(1583971200000))
+}
@@ -0,0 +1,105 @@
+package sim
+
+import "github.com/nntaoli-project/goex"
+
+type OrderEventType int
+
+const (
+	OrderEventType_New            OrderEventType = iota + 1 //订单被接受
+	OrderEventType_PartialFill                              //部分成交
+	OrderEventType_Filled                                   //全部成交
+	OrderEventType_Cancelled                                //撤单,或市价单、IOC单剩余部分撤销
+	OrderEventType_Rejected                                 //拒绝,如PostOnly会立即成交、条件单触发时资产不足
+	OrderEventType_CancelRejected                           //延迟到达的撤单失败,订单在撤单到达前已经结束,订单从撤单中变为最终状态
+)
+
+var orderEventTypeNames = map[OrderEventType]string{
+	OrderEventType_New:            "new",
+	OrderEventType_PartialFill:    "partial_fill",
+	OrderEventType_Filled:         "filled",
+	OrderEventType_Cancelled:      "cancelled",
+	OrderEventType_Rejected:       "rejected",
+	OrderEventType_CancelRejected: "cancel_rejected",
+}
+
+func (t OrderEventType) String() string {
+	return orderEventTypeNames[t]
+}
+
+//订单生命周期事件,成交事件带本次成交的价格、数量和手续费
+type OrderEvent struct {
+	Type       OrderEventType
+	Order      goex.Order //事件发生后的订单
+	FillPrice  float64
+	FillAmount float64
+	Fee        float64
+	IsTaker    bool
+	Timestamp  int64 //毫秒
+}
+
+//订阅订单事件。事件在撮合引擎释放锁之后按发生顺序同步推送,回调中可以继续下单、撤单
+func (ex *ExchangeSim) SubscribeOrderEvent(handler func(event *OrderEvent)) {
+	ex.Lock()
+	defer ex.Unlock()
+	ex.orderSubscribers = append(ex.orderSubscribers, handler)
+}
+
+//与goex websocket一致的订单回调,只关心订单最新状态时使用
+func (ex *ExchangeSim) OrderCallback(call func(order *goex.Order)) {
+	ex.SubscribeOrderEvent(func(event *OrderEvent) {
+		call(&event.Order)
+	})
+}
+
+func (ex *ExchangeSim) emitOrderEvent(typ OrderEventType, ord *goex.Order) {
+	ex.emitFillEvent(typ, ord, 0, 0, 0, false)
+}
+
+func (ex *ExchangeSim) emitFillEvent(typ OrderEventType, ord *goex.Order, price, amount, fee float64, isTaker bool) {
+	if len(ex.orderSubscribers) == 0 {
+		return
+	}
+
+	event := &OrderEvent{
+		Type:       typ,
+		Order:      ex.orderView(ord),
+		FillPrice:  price,
+		FillAmount: amount,
+		Fee:        fee,
+		IsTaker:    isTaker,
+		Timestamp:  ex.now(),
+	}
+	ex.orderEvents = append(ex.orderEvents, event)
+}
+
+//推送积压的订单事件,必须在不持有锁时调用;推送过程中产生的新事件由同一次调用继续推送
+func (ex *ExchangeSim) flushOrderEvents() {
+	ex.Lock()
+	if ex.flushingEvents {
+		ex.Unlock()
+		return
+	}
+	ex.flushingEvents = true
+	ex.Unlock()
+
+	for {
+		ex.Lock()
+		events := ex.orderEvents
+		ex.orderEvents = nil
+		subscribers := ex.orderSubscribers
+		if len(events) == 0 {
+			ex.flushingEvents = false
+		}
+		ex.Unlock()
+
+		if len(events) == 0 {
+			return
+		}
+
+		for _, event := range events {
+			for _, handler := range subscribers {
+				handler(event)
+			}
+		}
+	}
+}
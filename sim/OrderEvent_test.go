@@ -0,0 +1,84 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExchangeSim_OrderEvents(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+
+	var events []*OrderEvent
+	ex.SubscribeOrderEvent(func(event *OrderEvent) {
+		events = append(events, event)
+	})
+
+	//部分成交后撤单
+	ord, _ := ex.LimitBuy("1", "7001", goex.BTC_USDT)
+	assert.Len(t, events, 2)
+	assert.Equal(t, OrderEventType_New, events[0].Type)
+	assert.Equal(t, goex.ORDER_UNFINISH, events[0].Order.Status)
+	assert.Equal(t, OrderEventType_PartialFill, events[1].Type)
+	assert.Equal(t, 7001.0, events[1].FillPrice)
+	assert.Equal(t, 0.5, events[1].FillAmount)
+	assert.InDelta(t, 0.5*0.0002, events[1].Fee, 1e-12)
+	assert.True(t, events[1].IsTaker)
+
+	ex.CancelOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Len(t, events, 3)
+	assert.Equal(t, OrderEventType_Cancelled, events[2].Type)
+	assert.Equal(t, goex.ORDER_CANCEL, events[2].Order.Status)
+
+	//PostOnly拒绝
+	ex.LimitSell("0.1", "7000", goex.BTC_USDT, goex.PostOnly)
+	assert.Len(t, events, 5)
+	assert.Equal(t, OrderEventType_Rejected, events[4].Type)
+
+	//全部成交
+	ex.LimitSell("0.5", "6999", goex.BTC_USDT)
+	assert.Len(t, events, 7)
+	assert.Equal(t, OrderEventType_Filled, events[6].Type)
+	assert.Equal(t, 7000.0, events[6].FillPrice)
+	assert.Equal(t, goex.ORDER_FINISH, events[6].Order.Status)
+}
+
+func TestExchangeSim_OrderCallback(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+
+	//回调中可以继续下单,新事件排在后面推送
+	var statuses []goex.TradeStatus
+	ex.OrderCallback(func(order *goex.Order) {
+		statuses = append(statuses, order.Status)
+		if order.Side == goex.BUY && order.Status == goex.ORDER_FINISH {
+			ex.LimitSell("0.5", "7003", goex.BTC_USDT)
+		}
+	})
+
+	ex.LimitBuy("0.5", "7001", goex.BTC_USDT)
+	assert.Equal(t, []goex.TradeStatus{goex.ORDER_UNFINISH, goex.ORDER_FINISH, goex.ORDER_UNFINISH}, statuses)
+
+	orders, _ := ex.GetUnfinishOrders(goex.BTC_USDT)
+	assert.Len(t, orders, 1)
+}
+
+func TestExchangeSim_MarketOrderEvents(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+
+	var types []OrderEventType
+	ex.SubscribeOrderEvent(func(event *OrderEvent) {
+		types = append(types, event.Type)
+	})
+
+	//市价买单逐档成交,最后一个filled事件由结束订单时推送
+	ex.MarketBuy("5251", "", goex.BTC_USDT)
+	assert.Equal(t, []OrderEventType{OrderEventType_New, OrderEventType_PartialFill,
+		OrderEventType_PartialFill, OrderEventType_Filled}, types)
+
+	//市价卖单最后一笔成交即为filled,不重复推送
+	types = nil
+	ex.MarketSell("0.8", "", goex.BTC_USDT)
+	assert.Equal(t, []OrderEventType{OrderEventType_New, OrderEventType_PartialFill,
+		OrderEventType_Filled}, types)
+}
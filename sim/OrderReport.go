@@ -0,0 +1,234 @@
+package sim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/nntaoli-project/goex"
+	"html/template"
+	"log"
+	"os"
+	"sort"
+)
+
+var (
+	OrdersCsvFileName     = "%s_orders.csv"
+	RoundTripsCsvFileName = "%s_round_trips.csv"
+	OrderReportHtmlFile   = "order_report.html"
+)
+
+//一个模拟交易所的订单统计
+type OrderStats struct {
+	ExName        string
+	Total         int
+	ByStatus      map[string]int
+	BySide        map[string]int
+	FillRatio     float64            //全部成交的订单占比
+	CancelRatio   float64            //撤单(含部分成交后撤单)占比
+	PriceImprove  float64            //限价单成交均价相对挂单价的平均改善(正为更优),比例
+	MakerAmount   float64            //maker成交数量
+	TakerAmount   float64            //taker成交数量
+	MakerRatio    float64            //maker成交数量占比
+	Fees          map[string]float64 //按币种汇总的手续费
+	Orders        []OrderReportItem
+	RoundTrips    []RoundTrip
+	improveCount  int
+	improveAmount float64
+}
+
+//订单明细
+type OrderReportItem struct {
+	goex.Order
+	MakerAmount float64
+	TakerAmount float64
+	Fills       int
+}
+
+var ordersCsvHeader = []string{"OrderId", "Pair", "Side", "Type", "Feature", "Status", "Price", "Amount",
+	"AvgPrice", "DealAmount", "Fee", "MakerAmount", "TakerAmount", "Fills", "OrderTime", "FinishedTime"}
+
+func (ex *ExchangeSim) orderStats() *OrderStats {
+	ex.RLock()
+	defer ex.RUnlock()
+
+	stats := &OrderStats{
+		ExName:   ex.name,
+		ByStatus: make(map[string]int, 6),
+		BySide:   make(map[string]int, 4),
+		Fees:     make(map[string]float64, 2),
+	}
+	stats.RoundTrips = make([]RoundTrip, len(ex.tracker.roundTrips))
+	copy(stats.RoundTrips, ex.tracker.roundTrips)
+
+	items := make(map[string]*OrderReportItem, len(ex.finishedOrders))
+	for id, ord := range ex.finishedOrders {
+		items[id] = &OrderReportItem{Order: *ord}
+	}
+
+	for _, fill := range ex.fills {
+		stats.Fees[fill.FeeCurrency.Symbol] += fill.Fee
+		if fill.IsTaker {
+			stats.TakerAmount += fill.Amount
+		} else {
+			stats.MakerAmount += fill.Amount
+		}
+
+		item := items[fill.OrderId]
+		if item == nil { //未完成订单
+			continue
+		}
+		item.Fills++
+		if fill.IsTaker {
+			item.TakerAmount += fill.Amount
+		} else {
+			item.MakerAmount += fill.Amount
+		}
+	}
+	if stats.MakerAmount+stats.TakerAmount > 0 {
+		stats.MakerRatio = stats.MakerAmount / (stats.MakerAmount + stats.TakerAmount)
+	}
+
+	for _, item := range items {
+		stats.add(item)
+	}
+	if stats.Total > 0 {
+		stats.FillRatio = float64(stats.ByStatus[goex.ORDER_FINISH.String()]) / float64(stats.Total)
+		stats.CancelRatio = float64(stats.ByStatus[goex.ORDER_CANCEL.String()]) / float64(stats.Total)
+	}
+	if stats.improveCount > 0 {
+		stats.PriceImprove = stats.improveAmount / float64(stats.improveCount)
+	}
+
+	sort.Slice(stats.Orders, func(i, j int) bool {
+		if stats.Orders[i].OrderTime != stats.Orders[j].OrderTime {
+			return stats.Orders[i].OrderTime < stats.Orders[j].OrderTime
+		}
+		return stats.Orders[i].OrderID2 < stats.Orders[j].OrderID2
+	})
+
+	return stats
+}
+
+func (stats *OrderStats) add(item *OrderReportItem) {
+	stats.Total++
+	stats.ByStatus[item.Status.String()]++
+	stats.BySide[item.Side.String()]++
+	stats.Orders = append(stats.Orders, *item)
+
+	if execType(item.Order) != "limit" || item.DealAmount <= 0 || item.Price <= 0 {
+		return
+	}
+	improve := (item.AvgPrice - item.Price) / item.Price
+	if item.Side == goex.BUY || item.Side == goex.BUY_MARKET {
+		improve = -improve
+	}
+	stats.improveCount++
+	stats.improveAmount += improve
+}
+
+func (stats *OrderStats) writeCsv() {
+	csvFile := fmt.Sprintf(OrdersCsvFileName, stats.ExName)
+	f, err := os.OpenFile(csvFile, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0744)
+	if err != nil {
+		log.Printf("[ERROR] create the orders file %s error=%s", csvFile, err)
+		return
+	}
+	defer f.Close()
+
+	csvW := csv.NewWriter(f)
+	csvW.Write(ordersCsvHeader)
+	for _, ord := range stats.Orders {
+		csvW.Write([]string{
+			ord.OrderID2,
+			ord.Currency.ToSymbol("_"),
+			ord.Side.String(),
+			ord.Type,
+			fmt.Sprint(ord.OrderType),
+			ord.Status.String(),
+			goex.FloatToString(ord.Price, 8),
+			goex.FloatToString(ord.Amount, 8),
+			goex.FloatToString(ord.AvgPrice, 8),
+			goex.FloatToString(ord.DealAmount, 8),
+			goex.FloatToString(ord.Fee, 8),
+			goex.FloatToString(ord.MakerAmount, 8),
+			goex.FloatToString(ord.TakerAmount, 8),
+			fmt.Sprint(ord.Fills),
+			fmt.Sprint(ord.OrderTime),
+			fmt.Sprint(ord.FinishedTime),
+		})
+	}
+	csvW.Flush()
+}
+
+var roundTripsCsvHeader = []string{"Pair", "Side", "Amount", "OpenPrice", "ClosePrice", "OpenTime", "CloseTime",
+	"Fee", "Funding", "PnL", "MAE", "MFE"}
+
+func (stats *OrderStats) writeRoundTripsCsv() {
+	csvFile := fmt.Sprintf(RoundTripsCsvFileName, stats.ExName)
+	f, err := os.OpenFile(csvFile, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0744)
+	if err != nil {
+		log.Printf("[ERROR] create the round trips file %s error=%s", csvFile, err)
+		return
+	}
+	defer f.Close()
+
+	csvW := csv.NewWriter(f)
+	csvW.Write(roundTripsCsvHeader)
+	for _, trip := range stats.RoundTrips {
+		csvW.Write([]string{
+			trip.Pair.ToSymbol("_"),
+			trip.Side.String(),
+			goex.FloatToString(trip.Amount, 8),
+			goex.FloatToString(trip.OpenPrice, 8),
+			goex.FloatToString(trip.ClosePrice, 8),
+			fmt.Sprint(trip.OpenTime),
+			fmt.Sprint(trip.CloseTime),
+			goex.FloatToString(trip.Fee, 8),
+			goex.FloatToString(trip.Funding, 8),
+			goex.FloatToString(trip.PnL, 8),
+			goex.FloatToString(trip.MAE, 8),
+			goex.FloatToString(trip.MFE, 8),
+		})
+	}
+	csvW.Flush()
+}
+
+var orderReportTemplate = template.Must(template.New("orders").Funcs(template.FuncMap{
+	"percent": func(v float64) string { return fmt.Sprintf("%.2f%%", v*100) },
+	"float":   func(v float64) string { return goex.FloatToString(v, 8) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>订单报告</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; margin-bottom: 24px; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th { background: #f0f0f0; }
+</style>
+</head>
+<body>
+{{range .}}
+<h2>{{.ExName}}</h2>
+<table>
+<tr><th>订单数</th><td>{{.Total}}</td></tr>
+{{range $k, $v := .ByStatus}}<tr><th>{{$k}}</th><td>{{$v}}</td></tr>
+{{end}}{{range $k, $v := .BySide}}<tr><th>{{$k}}</th><td>{{$v}}</td></tr>
+{{end}}<tr><th>成交率</th><td>{{percent .FillRatio}}</td></tr>
+<tr><th>撤单率</th><td>{{percent .CancelRatio}}</td></tr>
+<tr><th>成交价改善</th><td>{{percent .PriceImprove}}</td></tr>
+<tr><th>maker/taker成交量</th><td>{{float .MakerAmount}} / {{float .TakerAmount}} ({{percent .MakerRatio}} maker)</td></tr>
+{{range $k, $v := .Fees}}<tr><th>{{$k}}手续费</th><td>{{float $v}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>OrderId</th><th>Pair</th><th>Side</th><th>Type</th><th>Status</th><th>Price</th><th>Amount</th><th>AvgPrice</th><th>DealAmount</th><th>Fee</th><th>Maker</th><th>Taker</th><th>OrderTime</th><th>FinishedTime</th></tr>
+{{range .Orders}}<tr><td>{{.OrderID2}}</td><td>{{.Currency.ToSymbol "_"}}</td><td>{{.Side}}</td><td>{{.Type}}</td><td>{{.Status}}</td><td>{{float .Price}}</td><td>{{float .Amount}}</td><td>{{float .AvgPrice}}</td><td>{{float .DealAmount}}</td><td>{{float .Fee}}</td><td>{{float .MakerAmount}}</td><td>{{float .TakerAmount}}</td><td>{{.OrderTime}}</td><td>{{.FinishedTime}}</td></tr>
+{{end}}</table>
+{{if .RoundTrips}}<table>
+<tr><th>Pair</th><th>Side</th><th>Amount</th><th>OpenPrice</th><th>ClosePrice</th><th>OpenTime</th><th>CloseTime</th><th>Holding</th><th>Fee</th><th>Funding</th><th>PnL</th><th>MAE</th><th>MFE</th></tr>
+{{range .RoundTrips}}<tr><td>{{.Pair.ToSymbol "_"}}</td><td>{{.Side}}</td><td>{{float .Amount}}</td><td>{{float .OpenPrice}}</td><td>{{float .ClosePrice}}</td><td>{{.OpenTime}}</td><td>{{.CloseTime}}</td><td>{{.HoldingTime}}</td><td>{{float .Fee}}</td><td>{{float .Funding}}</td><td>{{float .PnL}}</td><td>{{float .MAE}}</td><td>{{float .MFE}}</td></tr>
+{{end}}</table>{{end}}
+{{end}}
+</body>
+</html>
+`))
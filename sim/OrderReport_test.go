@@ -0,0 +1,40 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExchangeSim_OrderStats(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+
+	buy, _ := ex.LimitBuy("1", "7001", goex.BTC_USDT)
+	ex.CancelOrder(buy.OrderID2, goex.BTC_USDT)
+	ex.LimitSell("0.1", "7000", goex.BTC_USDT, goex.PostOnly)
+	ex.LimitSell("0.5", "6999", goex.BTC_USDT)
+	ex.LimitBuy("0.1", "6000", goex.BTC_USDT) //未完成订单不统计
+
+	stats := ex.orderStats()
+	assert.Equal(t, 3, stats.Total)
+	assert.Equal(t, 1, stats.ByStatus[goex.ORDER_CANCEL.String()])
+	assert.Equal(t, 1, stats.ByStatus[goex.ORDER_REJECT.String()])
+	assert.Equal(t, 1, stats.ByStatus[goex.ORDER_FINISH.String()])
+	assert.Equal(t, 1, stats.BySide[goex.BUY.String()])
+	assert.Equal(t, 2, stats.BySide[goex.SELL.String()])
+	assert.InDelta(t, 1.0/3, stats.FillRatio, 1e-12)
+	assert.InDelta(t, 1.0/3, stats.CancelRatio, 1e-12)
+
+	//买单按挂单价成交,卖单比挂单价高1
+	assert.InDelta(t, (7000.0-6999)/6999/2, stats.PriceImprove, 1e-12)
+	assert.Equal(t, 1.0, stats.TakerAmount)
+	assert.Equal(t, 0.0, stats.MakerRatio)
+	assert.InDelta(t, 0.5*0.0002, stats.Fees["BTC"], 1e-12)
+	assert.InDelta(t, 0.5*7000*0.0002, stats.Fees["USDT"], 1e-8)
+
+	assert.Len(t, stats.Orders, 3)
+	assert.Equal(t, buy.OrderID2, stats.Orders[0].OrderID2)
+	assert.Equal(t, 0.5, stats.Orders[0].TakerAmount)
+	assert.Equal(t, 1, stats.Orders[0].Fills)
+}
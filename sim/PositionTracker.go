@@ -0,0 +1,345 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"math"
+	"time"
+)
+
+const flatAmount = 1e-9
+
+//一笔开仓与平仓配对的交易,盈亏已扣除开平仓手续费(计价币)
+type RoundTrip struct {
+	Pair       goex.CurrencyPair
+	Side       goex.TradeSide //BUY为多头,SELL为空头
+	Amount     float64
+	OpenPrice  float64
+	ClosePrice float64
+	OpenTime   int64 //毫秒
+	CloseTime  int64
+	Fee        float64
+	Funding    float64 //持仓期间的资金费用,正为收入,已计入PnL
+	PnL        float64
+	MAE        float64 //持仓期间最大浮亏
+	MFE        float64 //持仓期间最大浮盈
+	QuoteRate  float64 //平仓时1个计价币折合的净值币种数量,统计指标时用于汇总不同计价币的币对
+}
+
+func (t RoundTrip) HoldingTime() time.Duration {
+	return time.Duration(t.CloseTime-t.OpenTime) * time.Millisecond
+}
+
+//币对的持仓和盈亏,Amount正为多头,负为空头
+type PositionPnL struct {
+	Pair          goex.CurrencyPair
+	Amount        float64
+	AvgPrice      float64
+	RealizedPnL   float64
+	UnrealizedPnL float64
+}
+
+//一笔还未平掉的开仓
+type positionLot struct {
+	amount   float64 //正为多头,负为空头
+	price    float64
+	fee      float64 //每单位的开仓手续费
+	funding  float64 //未平仓部分累计的资金费用
+	openTime int64
+	high     float64 //开仓以来的最高价
+	low      float64
+}
+
+type trackedPosition struct {
+	lots      []*positionLot //现货或合约多头的持仓,方向都相同
+	shortLots []*positionLot //合约双向持仓的空头
+	realized  float64
+	heldStart int64 //本次从空仓开仓的时间
+	held      int64 //之前有持仓的累计时间(毫秒)
+}
+
+//按成交把开仓和平仓配对成RoundTrip。现货的初始持仓在币对第一次有价格时按该价格记为多头,
+//卖出初始持仓是平多而不是开空;合约双向持仓时按开平仓类型分别配对多头和空头,
+//资金费用按数量分摊到未平仓的开仓,平仓时计入RoundTrip
+type PositionTracker struct {
+	method     model.CostMethodType
+	valuation  func(currency goex.Currency) (float64, bool) //币种折算成净值币种的价格,nil时不折算
+	inventory  map[string]float64                           //还没记为持仓的现货初始持仓,key为币对
+	positions  map[string]*trackedPosition
+	roundTrips []RoundTrip
+	notional   float64 //累计成交额(净值币种)
+}
+
+func newPositionTracker(method model.CostMethodType) *PositionTracker {
+	if method == 0 {
+		method = model.CostMethodType_FIFO
+	}
+	return &PositionTracker{
+		method:    method,
+		positions: make(map[string]*trackedPosition, 2),
+	}
+}
+
+func (t *PositionTracker) position(pair goex.CurrencyPair) *trackedPosition {
+	key := pair.ToSymbol("_")
+	pos := t.positions[key]
+	if pos == nil {
+		pos = &trackedPosition{}
+		t.positions[key] = pos
+	}
+	return pos
+}
+
+//1个计价币折合的净值币种数量,没有估值价格时为0
+func (t *PositionTracker) quoteRate(pair goex.CurrencyPair) float64 {
+	if t.valuation == nil {
+		return 1
+	}
+	rate, ok := t.valuation(pair.CurrencyB)
+	if !ok {
+		return 0
+	}
+	return rate
+}
+
+func (t *PositionTracker) onFill(fill Fill) {
+	rate := t.quoteRate(fill.Pair)
+	t.notional += fill.Price * fill.Amount * rate
+
+	//手续费扣基础币时实际到账数量减少,手续费都折算成计价币
+	qty, fee := fill.Amount, fill.Fee
+	if fill.FeeCurrency.Eq(fill.Pair.CurrencyA) {
+		qty -= fill.Fee
+		fee = fill.Fee * fill.Price
+	}
+	if qty <= 0 {
+		return
+	}
+	feePerUnit := fee / qty
+
+	dir := 1.0
+	if fill.Side == goex.SELL || fill.Side == goex.SELL_MARKET {
+		dir = -1
+	}
+
+	pos := t.position(fill.Pair)
+	flat := pos.flat()
+	lots := pos.book(fill.OType)
+	remain := qty
+	for remain > flatAmount && len(*lots) > 0 && (*lots)[0].amount*dir < 0 {
+		idx := 0
+		if t.method == model.CostMethodType_LIFO {
+			idx = len(*lots) - 1
+		}
+		lot := (*lots)[idx]
+		matched := math.Min(remain, math.Abs(lot.amount))
+		trip := lot.close(fill, matched, feePerUnit)
+		trip.QuoteRate = rate
+		t.roundTrips = append(t.roundTrips, trip)
+		pos.realized += trip.PnL
+
+		lot.amount += dir * matched
+		remain -= matched
+		if math.Abs(lot.amount) < flatAmount {
+			*lots = append((*lots)[:idx], (*lots)[idx+1:]...)
+		}
+	}
+
+	//合约平仓单不会反向开仓
+	if remain > flatAmount && fill.OType != goex.CLOSE_BUY && fill.OType != goex.CLOSE_SELL {
+		lot := &positionLot{
+			amount:   dir * remain,
+			price:    fill.Price,
+			fee:      feePerUnit,
+			openTime: fill.Timestamp,
+			high:     fill.Price,
+			low:      fill.Price,
+		}
+		if t.method == model.CostMethodType_Average && len(*lots) > 0 {
+			(*lots)[0].merge(lot)
+		} else {
+			*lots = append(*lots, lot)
+		}
+	}
+
+	switch {
+	case flat && !pos.flat():
+		pos.heldStart = fill.Timestamp
+	case !flat && pos.flat(): //本次成交平掉了全部持仓
+		pos.held += fill.Timestamp - pos.heldStart
+	}
+}
+
+//合约持仓的资金费用按数量分摊到该方向未平仓的开仓,oType为OPEN_BUY或OPEN_SELL
+func (t *PositionTracker) onFunding(pair goex.CurrencyPair, oType int, amount float64) {
+	pos := t.positions[pair.ToSymbol("_")]
+	if pos == nil {
+		return
+	}
+	lots := *pos.book(oType)
+	var total float64
+	for _, lot := range lots {
+		total += math.Abs(lot.amount)
+	}
+	if total <= 0 {
+		return
+	}
+	for _, lot := range lots {
+		lot.funding += amount * math.Abs(lot.amount) / total
+	}
+}
+
+//成交所在的持仓,现货和合约多头在lots,合约空头在shortLots
+func (pos *trackedPosition) book(oType int) *[]*positionLot {
+	if oType == goex.OPEN_SELL || oType == goex.CLOSE_SELL {
+		return &pos.shortLots
+	}
+	return &pos.lots
+}
+
+func (pos *trackedPosition) allLots() []*positionLot {
+	lots := make([]*positionLot, 0, len(pos.lots)+len(pos.shortLots))
+	return append(append(lots, pos.lots...), pos.shortLots...)
+}
+
+func (pos *trackedPosition) flat() bool {
+	return len(pos.lots) == 0 && len(pos.shortLots) == 0
+}
+
+//币对第一次有价格时把初始持仓记为多头
+func (t *PositionTracker) seed(pair goex.CurrencyPair, price float64, now int64) {
+	key := pair.ToSymbol("_")
+	amount := t.inventory[key]
+	if amount <= 0 || price <= 0 {
+		return
+	}
+	delete(t.inventory, key)
+
+	pos := t.position(pair)
+	if pos.flat() {
+		pos.heldStart = now
+	}
+	pos.lots = append([]*positionLot{{
+		amount:   amount,
+		price:    price,
+		openTime: now,
+		high:     price,
+		low:      price,
+	}}, pos.lots...)
+}
+
+//用最新行情更新持仓期间的最高、最低价
+func (t *PositionTracker) onPrice(pair goex.CurrencyPair, high, low float64) {
+	pos := t.positions[pair.ToSymbol("_")]
+	if pos == nil || high <= 0 || low <= 0 {
+		return
+	}
+	for _, lot := range pos.allLots() {
+		lot.high = math.Max(lot.high, high)
+		lot.low = math.Min(lot.low, low)
+	}
+}
+
+func (t *PositionTracker) positionPnL(pair goex.CurrencyPair, markPrice float64) PositionPnL {
+	pnl := PositionPnL{Pair: pair}
+	pos := t.positions[pair.ToSymbol("_")]
+	if pos == nil {
+		return pnl
+	}
+
+	pnl.RealizedPnL = pos.realized
+	var cost float64
+	for _, lot := range pos.allLots() {
+		pnl.Amount += lot.amount
+		cost += lot.price * lot.amount
+		pnl.UnrealizedPnL += (markPrice-lot.price)*lot.amount - lot.fee*math.Abs(lot.amount) + lot.funding
+	}
+	if pnl.Amount != 0 {
+		pnl.AvgPrice = cost / pnl.Amount
+	}
+	if markPrice <= 0 {
+		pnl.UnrealizedPnL = 0
+	}
+	return pnl
+}
+
+//有持仓的累计时间,未平仓的持仓算到now
+func (t *PositionTracker) heldTime(now int64) int64 {
+	var held int64
+	for _, pos := range t.positions {
+		held += pos.held
+		if !pos.flat() && now > pos.heldStart {
+			held += now - pos.heldStart
+		}
+	}
+	return held
+}
+
+func (lot *positionLot) close(fill Fill, amount, feePerUnit float64) RoundTrip {
+	lot.high = math.Max(lot.high, fill.Price)
+	lot.low = math.Min(lot.low, fill.Price)
+
+	trip := RoundTrip{
+		Pair:       fill.Pair,
+		Side:       goex.BUY,
+		Amount:     amount,
+		OpenPrice:  lot.price,
+		ClosePrice: fill.Price,
+		OpenTime:   lot.openTime,
+		CloseTime:  fill.Timestamp,
+		Fee:        (lot.fee + feePerUnit) * amount,
+		MAE:        (lot.price - lot.low) * amount,
+		MFE:        (lot.high - lot.price) * amount,
+	}
+	if lot.amount < 0 {
+		trip.Side = goex.SELL
+		trip.MAE, trip.MFE = trip.MFE, trip.MAE
+	}
+	trip.Funding = lot.funding * amount / math.Abs(lot.amount)
+	lot.funding -= trip.Funding
+	trip.PnL = (fill.Price-lot.price)*amount - trip.Fee + trip.Funding
+	if lot.amount < 0 {
+		trip.PnL = (lot.price-fill.Price)*amount - trip.Fee + trip.Funding
+	}
+	return trip
+}
+
+//移动平均成本:加仓后按数量加权平均开仓价和手续费,开仓时间保留最早的
+func (lot *positionLot) merge(other *positionLot) {
+	amount := lot.amount + other.amount
+	lot.price = (lot.price*lot.amount + other.price*other.amount) / amount
+	lot.fee = (lot.fee*lot.amount + other.fee*other.amount) / amount
+	lot.funding += other.funding
+	lot.amount = amount
+	lot.high = math.Max(lot.high, other.high)
+	lot.low = math.Min(lot.low, other.low)
+}
+
+//币对已平仓的配对交易
+func (ex *ExchangeSim) GetRoundTrips(currencyPair goex.CurrencyPair) []RoundTrip {
+	ex.RLock()
+	defer ex.RUnlock()
+
+	var trips []RoundTrip
+	for _, trip := range ex.tracker.roundTrips {
+		if trip.Pair.Eq(currencyPair) {
+			trips = append(trips, trip)
+		}
+	}
+	return trips
+}
+
+//币对当前的持仓、已实现盈亏和按最新价计算的未实现盈亏
+func (ex *ExchangeSim) GetPositionPnL(currencyPair goex.CurrencyPair) PositionPnL {
+	ex.RLock()
+	defer ex.RUnlock()
+	return ex.tracker.positionPnL(currencyPair, ex.lastPrices[currencyPair.ToSymbol("_")])
+}
+
+func (f *FutureExchangeSim) GetRoundTrips(currencyPair goex.CurrencyPair) []RoundTrip {
+	return f.engine.GetRoundTrips(currencyPair)
+}
+
+func (f *FutureExchangeSim) GetPositionPnL(currencyPair goex.CurrencyPair) PositionPnL {
+	return f.engine.GetPositionPnL(currencyPair)
+}
@@ -0,0 +1,109 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func trackFills(method model.CostMethodType) *PositionTracker {
+	tracker := newPositionTracker(method)
+	tracker.onFill(Fill{Pair: goex.BTC_USDT, Side: goex.BUY, Price: 100, Amount: 1, FeeCurrency: goex.USDT, Timestamp: 1000})
+	tracker.onFill(Fill{Pair: goex.BTC_USDT, Side: goex.BUY, Price: 120, Amount: 1, FeeCurrency: goex.USDT, Timestamp: 2000})
+	tracker.onPrice(goex.BTC_USDT, 130, 90)
+	tracker.onFill(Fill{Pair: goex.BTC_USDT, Side: goex.SELL, Price: 110, Amount: 1, Fee: 0.5, FeeCurrency: goex.USDT, Timestamp: 3000})
+	return tracker
+}
+
+func TestPositionTracker_CostMethod(t *testing.T) {
+	fifo := trackFills(model.CostMethodType_FIFO)
+	assert.Len(t, fifo.roundTrips, 1)
+	trip := fifo.roundTrips[0]
+	assert.Equal(t, goex.BUY, trip.Side)
+	assert.Equal(t, 100.0, trip.OpenPrice)
+	assert.Equal(t, int64(1000), trip.OpenTime)
+	assert.InDelta(t, 10-0.5, trip.PnL, 1e-12)
+	assert.Equal(t, 10.0, trip.MAE)
+	assert.Equal(t, 30.0, trip.MFE)
+
+	lifo := trackFills(model.CostMethodType_LIFO)
+	assert.Equal(t, 120.0, lifo.roundTrips[0].OpenPrice)
+	assert.InDelta(t, -10-0.5, lifo.roundTrips[0].PnL, 1e-12)
+
+	avg := trackFills(model.CostMethodType_Average)
+	assert.Equal(t, 110.0, avg.roundTrips[0].OpenPrice)
+	assert.Equal(t, int64(1000), avg.roundTrips[0].OpenTime)
+	assert.InDelta(t, -0.5, avg.roundTrips[0].PnL, 1e-12)
+
+	//剩余持仓按最新价计算未实现盈亏
+	pnl := fifo.positionPnL(goex.BTC_USDT, 125)
+	assert.Equal(t, 1.0, pnl.Amount)
+	assert.Equal(t, 120.0, pnl.AvgPrice)
+	assert.InDelta(t, 9.5, pnl.RealizedPnL, 1e-12)
+	assert.InDelta(t, 5, pnl.UnrealizedPnL, 1e-12)
+}
+
+func TestPositionTracker_FlipAndBaseFee(t *testing.T) {
+	tracker := newPositionTracker(0)
+
+	//买单手续费扣基础币,到账0.999
+	tracker.onFill(Fill{Pair: goex.BTC_USDT, Side: goex.BUY, Price: 100, Amount: 1, Fee: 0.001, FeeCurrency: goex.BTC, Timestamp: 1000})
+	pnl := tracker.positionPnL(goex.BTC_USDT, 100)
+	assert.InDelta(t, 0.999, pnl.Amount, 1e-12)
+	assert.InDelta(t, -0.1, pnl.UnrealizedPnL, 1e-12)
+
+	//卖出1.999,平多0.999后开空1
+	tracker.onFill(Fill{Pair: goex.BTC_USDT, Side: goex.SELL, Price: 110, Amount: 1.999, FeeCurrency: goex.USDT, Timestamp: 3000})
+	assert.Len(t, tracker.roundTrips, 1)
+	assert.InDelta(t, 0.999*10-0.1, tracker.roundTrips[0].PnL, 1e-9)
+	pnl = tracker.positionPnL(goex.BTC_USDT, 105)
+	assert.InDelta(t, -1, pnl.Amount, 1e-12)
+	assert.InDelta(t, 5, pnl.UnrealizedPnL, 1e-9)
+
+	tracker.onFill(Fill{Pair: goex.BTC_USDT, Side: goex.BUY, Price: 100, Amount: 1, FeeCurrency: goex.USDT, Timestamp: 4000})
+	assert.Len(t, tracker.roundTrips, 2)
+	assert.Equal(t, goex.SELL, tracker.roundTrips[1].Side)
+	assert.InDelta(t, 10, tracker.roundTrips[1].PnL, 1e-9)
+	assert.Equal(t, int64(3000), tracker.heldTime(10000))
+}
+
+func TestExchangeSim_GetRoundTrips(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+
+	ex.LimitBuy("0.5", "7001", goex.BTC_USDT)
+	ex.LimitSell("0.4999", "6999", goex.BTC_USDT)
+
+	//FIFO先平掉按第一次行情记为多头的初始持仓
+	trips := ex.GetRoundTrips(goex.BTC_USDT)
+	assert.Len(t, trips, 1)
+	assert.Equal(t, goex.BUY, trips[0].Side)
+	assert.Equal(t, 7000.0, trips[0].OpenPrice)
+	assert.Equal(t, 7000.0, trips[0].ClosePrice)
+	assert.InDelta(t, 0.4999, trips[0].Amount, 1e-12)
+	assert.InDelta(t, -0.4999*7000*0.0002, trips[0].PnL, 1e-6)
+
+	pnl := ex.GetPositionPnL(goex.BTC_USDT)
+	assert.InDelta(t, 1, pnl.Amount, 1e-12)
+	assert.InDelta(t, trips[0].PnL, pnl.RealizedPnL, 1e-12)
+}
+
+func TestExchangeSim_SellInitialInventory(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+
+	ex.LimitSell("0.5", "6999", goex.BTC_USDT)
+	ex.LimitBuy("0.5", "7001", goex.BTC_USDT)
+
+	//卖出初始持仓是平多,买回来是重新开多,不会出现空头
+	trips := ex.GetRoundTrips(goex.BTC_USDT)
+	assert.Len(t, trips, 1)
+	assert.Equal(t, goex.BUY, trips[0].Side)
+	assert.Equal(t, 7000.0, trips[0].OpenPrice)
+	assert.InDelta(t, 0.5, trips[0].Amount, 1e-12)
+	assert.InDelta(t, -0.5*7000*0.0002, trips[0].PnL, 1e-6)
+
+	acc, _ := ex.GetAccount()
+	pnl := ex.GetPositionPnL(goex.BTC_USDT)
+	assert.InDelta(t, acc.SubAccounts[goex.BTC].Amount, pnl.Amount, 1e-12)
+	assert.InDelta(t, 0.9999, pnl.Amount, 1e-12)
+}
@@ -0,0 +1,100 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"math"
+)
+
+//挂单排队:记录下单时同价位可见的挂单量,作为排在前面的数量(仅深度回测)
+func (ex *ExchangeSim) joinQueue(ord *goex.Order) {
+	if !ex.queueModel || ex.backTestDataType != model.BackTestDataType_Depth {
+		return
+	}
+
+	depth := ex.market(ord.Currency).depth
+	levels := depth.BidList
+	if ord.Side == goex.SELL {
+		levels = depth.AskList
+	}
+
+	ahead := 0.0
+	for _, level := range levels {
+		if level.Price == ord.Price {
+			ahead = level.Amount
+			break
+		}
+	}
+	ex.queueAhead[ord.OrderID2] = ahead
+}
+
+//根据新的深度快照更新挂单前方的排队数量,返回是否已排到可以成交:
+//1. 对手盘价格穿过挂单价,前面排队的全部成交,排队数量清零
+//2. 同价位挂单量减少,视为前面的挂单成交或撤单,排队数量不超过该价位剩余挂单量
+//3. 同价位已消失且本方最优价已劣于挂单价,排队数量清零
+//4. 对手盘正好挂在该价位时,其数量先成交给前面排队的挂单
+func (ex *ExchangeSim) updateQueuePosition(ord *goex.Order) bool {
+	ahead, ok := ex.queueAhead[ord.OrderID2]
+	if !ok || ahead <= 0 {
+		return true
+	}
+
+	m := ex.market(ord.Currency)
+
+	var (
+		sameSide goex.DepthRecords
+		opposite *goex.DepthRecord
+		beyond   func(price float64) bool //价格越过挂单价(对买单来说更低)
+	)
+
+	switch ord.Side {
+	case goex.BUY:
+		sameSide = m.depth.BidList
+		if len(m.depth.AskList) > 0 {
+			opposite = &m.depth.AskList[len(m.depth.AskList)-1]
+		}
+		beyond = func(price float64) bool { return price < ord.Price }
+	case goex.SELL:
+		sameSide = m.depth.AskList
+		if len(m.depth.BidList) > 0 {
+			opposite = &m.depth.BidList[0]
+		}
+		beyond = func(price float64) bool { return price > ord.Price }
+	}
+
+	if opposite != nil && opposite.Amount > 0 && beyond(opposite.Price) {
+		ahead = 0
+	} else {
+		found := false
+		for _, level := range sameSide {
+			if level.Price == ord.Price {
+				ahead = math.Min(ahead, level.Amount)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			if len(sameSide) == 0 {
+				ahead = 0
+			} else {
+				best := sameSide[0].Price
+				if ord.Side == goex.SELL {
+					best = sameSide[len(sameSide)-1].Price
+				}
+				if beyond(best) {
+					ahead = 0
+				}
+			}
+		}
+
+		if ahead > 0 && opposite != nil && opposite.Price == ord.Price {
+			eat := math.Min(ahead, m.liquidity.available(ord.Side, *opposite))
+			m.liquidity.consume(ord.Side, opposite.Price, eat)
+			ahead -= eat
+		}
+	}
+
+	ex.queueAhead[ord.OrderID2] = ahead
+	return ahead <= 0
+}
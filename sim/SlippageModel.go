@@ -0,0 +1,101 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"math"
+)
+
+//固定滑点,单位基点(万分之一)
+type FixedBpsSlippage struct {
+	Bps float64
+}
+
+func NewFixedBpsSlippage(bps float64) *FixedBpsSlippage {
+	return &FixedBpsSlippage{Bps: bps}
+}
+
+func (s *FixedBpsSlippage) Price(ctx model.SlippageContext) float64 {
+	return slip(ctx.Side, ctx.Price, s.Bps/10000)
+}
+
+//与波动率成正比的滑点: Multiplier * 波动率
+type VolatilitySlippage struct {
+	Multiplier float64
+}
+
+func NewVolatilitySlippage(multiplier float64) *VolatilitySlippage {
+	return &VolatilitySlippage{Multiplier: multiplier}
+}
+
+func (s *VolatilitySlippage) Price(ctx model.SlippageContext) float64 {
+	return slip(ctx.Side, ctx.Price, s.Multiplier*ctx.Volatility)
+}
+
+//平方根冲击模型: Coefficient * 波动率 * sqrt(成交数量 / 参考成交量)
+type SquareRootImpactSlippage struct {
+	Coefficient float64
+}
+
+func NewSquareRootImpactSlippage(coefficient float64) *SquareRootImpactSlippage {
+	return &SquareRootImpactSlippage{Coefficient: coefficient}
+}
+
+func (s *SquareRootImpactSlippage) Price(ctx model.SlippageContext) float64 {
+	if ctx.Volume <= 0 {
+		return ctx.Price
+	}
+	return slip(ctx.Side, ctx.Price, s.Coefficient*ctx.Volatility*math.Sqrt(ctx.Amount/ctx.Volume))
+}
+
+//买单价格上滑,卖单价格下滑
+func slip(side goex.TradeSide, price, ratio float64) float64 {
+	if side == goex.BUY {
+		return price * (1 + ratio)
+	}
+	return price * (1 - ratio)
+}
+
+//滚动计算最近价格的对数收益率标准差
+type priceVolatility struct {
+	window int
+	prices []float64
+}
+
+func newPriceVolatility(window int) *priceVolatility {
+	if window <= 1 {
+		window = 20
+	}
+	return &priceVolatility{window: window}
+}
+
+func (v *priceVolatility) add(price float64) {
+	if price <= 0 {
+		return
+	}
+	v.prices = append(v.prices, price)
+	if len(v.prices) > v.window {
+		v.prices = v.prices[len(v.prices)-v.window:]
+	}
+}
+
+func (v *priceVolatility) value() float64 {
+	if len(v.prices) < 3 {
+		return 0
+	}
+
+	returns := make([]float64, 0, len(v.prices)-1)
+	mean := 0.0
+	for i := 1; i < len(v.prices); i++ {
+		r := math.Log(v.prices[i] / v.prices[i-1])
+		returns = append(returns, r)
+		mean += r
+	}
+	mean /= float64(len(returns))
+
+	variance := 0.0
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	return math.Sqrt(variance / float64(len(returns)-1))
+}
@@ -0,0 +1,77 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestFixedBpsSlippage(t *testing.T) {
+	s := NewFixedBpsSlippage(10)
+	assert.InDelta(t, 10010.0, s.Price(model.SlippageContext{Side: goex.BUY, Price: 10000}), 1e-8)
+	assert.InDelta(t, 9990.0, s.Price(model.SlippageContext{Side: goex.SELL, Price: 10000}), 1e-8)
+}
+
+func TestVolatilitySlippage(t *testing.T) {
+	s := NewVolatilitySlippage(0.5)
+	price := s.Price(model.SlippageContext{Side: goex.BUY, Price: 10000, Volatility: 0.002})
+	assert.InDelta(t, 10010.0, price, 1e-8)
+}
+
+func TestSquareRootImpactSlippage(t *testing.T) {
+	s := NewSquareRootImpactSlippage(1)
+	price := s.Price(model.SlippageContext{Side: goex.SELL, Price: 10000, Amount: 25, Volume: 100, Volatility: 0.01})
+	assert.InDelta(t, 10000*(1-0.01*0.5), price, 1e-8)
+
+	//没有参考成交量时不计冲击
+	price = s.Price(model.SlippageContext{Side: goex.SELL, Price: 10000, Amount: 25, Volatility: 0.01})
+	assert.Equal(t, 10000.0, price)
+}
+
+func TestPriceVolatility(t *testing.T) {
+	v := newPriceVolatility(3)
+	for _, p := range []float64{100, 200, 100, 100, 100} {
+		v.add(p)
+	}
+	assert.Equal(t, 0.0, v.value())
+
+	v.add(110)
+	r := math.Log(1.1)
+	mean := r / 2
+	expected := math.Sqrt((mean*mean + (r-mean)*(r-mean)) / 1)
+	assert.InDelta(t, expected, v.value(), 1e-12)
+}
+
+func TestExchangeSim_TakerSlippage(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+	ex.slippage = NewFixedBpsSlippage(10)
+
+	ord, _ := ex.MarketBuy("3504.0005", "", goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.InDelta(t, 7001*1.001, ord.AvgPrice, 1e-6)
+	assert.InDelta(t, 0.5, ord.DealAmount, 1e-9)
+
+	acc, _ := ex.GetAccount()
+	assert.InDelta(t, 0.0, acc.SubAccounts[goex.USDT].ForzenAmount, 1e-6)
+	assert.InDelta(t, 100000-3504.0005, acc.SubAccounts[goex.USDT].Amount, 1e-6)
+
+	//限价单不会成交在比委托价更差的价格
+	ord, _ = ex.LimitSell("0.1", "6999", goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.InDelta(t, 6999.0, ord.AvgPrice, 1e-8)
+}
+
+func TestExchangeSim_KLine_SlippagePrecedence(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_KLine)
+	ex.slippage = NewFixedBpsSlippage(10)
+
+	//配置了滑点模型时不再叠加MarketOrderSlippage
+	ord, _ := ex.MarketBuy("7007", "", goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.InDelta(t, 7000*1.001, ord.AvgPrice, 1e-6)
+
+	ord, _ = ex.MarketSell("1", "", goex.BTC_USDT)
+	assert.InDelta(t, 7000*0.999, ord.AvgPrice, 1e-6)
+}
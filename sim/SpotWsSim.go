@@ -0,0 +1,256 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"time"
+)
+
+var (
+	DepthCallbackNotSetError  = errors.New("please set depth callback func")
+	TickerCallbackNotSetError = errors.New("please set ticker callback func")
+	TradeCallbackNotSetError  = errors.New("please set trade callback func")
+	KlineCallbackNotSetError  = errors.New("please set kline callback func")
+)
+
+type klineSubscription struct {
+	pair   goex.CurrencyPair
+	period goex.KlinePeriod
+}
+
+//模拟goex现货websocket,与OKExV3SpotWs的订阅方式一致。Run按回测时间推送订阅的行情,回调在推送过程中同步执行;
+//回测数据没有逐笔成交,成交推送的是撮合引擎的模拟成交,方向为taker方向
+type SpotWsSim struct {
+	ex             *ExchangeSim
+	depthCallback  func(*goex.Depth)
+	tickerCallback func(*goex.Ticker)
+	tradeCallback  func(*goex.Trade)
+	klineCallback  func(*goex.Kline, goex.KlinePeriod)
+	depthPairs     map[string]bool
+	tickerPairs    map[string]bool
+	tradePairs     map[string]bool
+	klines         []klineSubscription
+	trades         []*goex.Trade //还未推送的模拟成交
+	tid            int64
+}
+
+var _ goex.SpotWsApi = (*SpotWsSim)(nil)
+
+func NewSpotWsSim(ex *ExchangeSim) *SpotWsSim {
+	ws := &SpotWsSim{
+		ex:          ex,
+		depthPairs:  make(map[string]bool, 2),
+		tickerPairs: make(map[string]bool, 2),
+		tradePairs:  make(map[string]bool, 2),
+	}
+	ex.SubscribeOrderEvent(ws.onOrderEvent)
+	return ws
+}
+
+func (ws *SpotWsSim) TickerCallback(tickerCallback func(*goex.Ticker)) {
+	ws.tickerCallback = tickerCallback
+}
+
+func (ws *SpotWsSim) DepthCallback(depthCallback func(*goex.Depth)) {
+	ws.depthCallback = depthCallback
+}
+
+func (ws *SpotWsSim) TradeCallback(tradeCallback func(*goex.Trade)) {
+	ws.tradeCallback = tradeCallback
+}
+
+func (ws *SpotWsSim) KLineCallback(klineCallback func(kline *goex.Kline, period goex.KlinePeriod)) {
+	ws.klineCallback = klineCallback
+}
+
+func (ws *SpotWsSim) SetCallbacks(tickerCallback func(*goex.Ticker),
+	depthCallback func(*goex.Depth),
+	tradeCallback func(*goex.Trade),
+	klineCallback func(*goex.Kline, goex.KlinePeriod)) {
+	ws.tickerCallback = tickerCallback
+	ws.depthCallback = depthCallback
+	ws.tradeCallback = tradeCallback
+	ws.klineCallback = klineCallback
+}
+
+func (ws *SpotWsSim) SubscribeDepth(currencyPair goex.CurrencyPair) error {
+	if ws.depthCallback == nil {
+		return DepthCallbackNotSetError
+	}
+	ws.depthPairs[currencyPair.ToSymbol("_")] = true
+	return nil
+}
+
+func (ws *SpotWsSim) SubscribeTicker(currencyPair goex.CurrencyPair) error {
+	if ws.tickerCallback == nil {
+		return TickerCallbackNotSetError
+	}
+	ws.tickerPairs[currencyPair.ToSymbol("_")] = true
+	return nil
+}
+
+func (ws *SpotWsSim) SubscribeTrade(currencyPair goex.CurrencyPair) error {
+	if ws.tradeCallback == nil {
+		return TradeCallbackNotSetError
+	}
+	ws.tradePairs[currencyPair.ToSymbol("_")] = true
+	return nil
+}
+
+//K线回测时按订阅的K线推进行情
+func (ws *SpotWsSim) SubscribeKline(currencyPair goex.CurrencyPair, period int) error {
+	if ws.klineCallback == nil {
+		return KlineCallbackNotSetError
+	}
+	ws.klines = append(ws.klines, klineSubscription{pair: currencyPair, period: goex.KlinePeriod(period)})
+	return nil
+}
+
+//按回测时间推送行情直到数据结束,ctx取消时返回ctx.Err()
+func (ws *SpotWsSim) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		var ok bool
+		if ws.ex.BackTestDataType() == model.BackTestDataType_KLine {
+			ok = ws.pushKlines()
+		} else {
+			ok = ws.pushDepth()
+		}
+		if !ok {
+			return nil
+		}
+	}
+}
+
+func (ws *SpotWsSim) pushDepth() bool {
+	depth, err := ws.ex.NextDepth()
+	if err != nil {
+		return false
+	}
+
+	pair := depth.Pair.ToSymbol("_")
+	if ws.depthPairs[pair] {
+		ws.depthCallback(depth)
+		ws.pushTrades()
+	}
+	if ws.tickerPairs[pair] {
+		ticker, err := ws.ex.GetTicker(depth.Pair)
+		if err == nil {
+			ws.tickerCallback(ticker)
+			ws.pushTrades()
+		}
+	}
+	ws.pushTrades()
+
+	return true
+}
+
+//K线回测每次推进所有订阅的K线各一根,只订阅了ticker的币对按1分钟K线推进
+func (ws *SpotWsSim) pushKlines() bool {
+	subscriptions := append([]klineSubscription{}, ws.klines...)
+	for pair := range ws.tickerPairs {
+		found := false
+		for _, sub := range ws.klines {
+			if sub.pair.ToSymbol("_") == pair {
+				found = true
+				break
+			}
+		}
+		if !found {
+			subscriptions = append(subscriptions, klineSubscription{pair: goex.NewCurrencyPair2(pair), period: goex.KLINE_PERIOD_1MIN})
+		}
+	}
+	if len(subscriptions) == 0 {
+		return false
+	}
+
+	tickerPushed := make(map[string]bool, len(ws.tickerPairs))
+	for _, sub := range subscriptions {
+		klines, err := ws.ex.GetKlineRecords(sub.pair, sub.period, 1)
+		if err != nil {
+			return false
+		}
+		kline := klines[0]
+		kline.Pair = sub.pair
+
+		if ws.klineCallback != nil && ws.isKlineSubscribed(sub) {
+			ws.klineCallback(&kline, sub.period)
+			ws.pushTrades()
+		}
+
+		pair := sub.pair.ToSymbol("_")
+		if ws.tickerPairs[pair] && !tickerPushed[pair] {
+			tickerPushed[pair] = true
+			ws.tickerCallback(&goex.Ticker{
+				Pair: sub.pair,
+				Last: kline.Close,
+				Buy:  kline.Close,
+				Sell: kline.Close,
+				High: kline.High,
+				Low:  kline.Low,
+				Vol:  kline.Vol,
+				Date: uint64(ws.ex.now()),
+			})
+			ws.pushTrades()
+		}
+	}
+	ws.pushTrades()
+
+	return true
+}
+
+func (ws *SpotWsSim) isKlineSubscribed(sub klineSubscription) bool {
+	for _, s := range ws.klines {
+		if s.pair.Eq(sub.pair) && s.period == sub.period {
+			return true
+		}
+	}
+	return false
+}
+
+func (ws *SpotWsSim) onOrderEvent(event *OrderEvent) {
+	if event.FillAmount <= 0 || !ws.tradePairs[event.Order.Currency.ToSymbol("_")] {
+		return
+	}
+
+	side := event.Order.Side
+	if !event.IsTaker {
+		side = goex.BUY
+		if event.Order.Side == goex.BUY {
+			side = goex.SELL
+		}
+	}
+
+	ws.tid++
+	ws.trades = append(ws.trades, &goex.Trade{
+		Tid:    ws.tid,
+		Type:   side,
+		Amount: event.FillAmount,
+		Price:  event.FillPrice,
+		Date:   event.Timestamp,
+		Pair:   event.Order.Currency,
+	})
+}
+
+//推送回调过程中产生的模拟成交
+func (ws *SpotWsSim) pushTrades() {
+	for len(ws.trades) > 0 {
+		trades := ws.trades
+		ws.trades = nil
+		for _, trade := range trades {
+			ws.tradeCallback(trade)
+		}
+	}
+}
+
+//当前回测时间
+func (ws *SpotWsSim) Now() time.Time {
+	return time.Unix(0, ws.ex.now()*int64(time.Millisecond))
+}
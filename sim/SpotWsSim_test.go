@@ -0,0 +1,83 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSpotWsSim_Depth(t *testing.T) {
+	os.MkdirAll("data", 0755)
+	defer os.Remove("data")
+	files := map[string]string{
+		"btcusdt": "1583971200000,7001,1,7000,1\n1583971202000,7011,1,7010,1\n1583971204000,7021,1,7020,1\n",
+		"ethusdt": "1583971201000,201,1,200,1\n1583971203000,190,1,189,1\n",
+	}
+	for symbol, data := range files {
+		fileName := fmt.Sprintf("data/ws.test_%s_2020-03-12.csv", symbol)
+		ioutil.WriteFile(fileName, []byte(data), 0644)
+		defer os.Remove(fileName)
+	}
+
+	ex := newExchangeSim(model.ExchangeSimConfig{
+		ExName:               "ws.test",
+		QuoteCurrency:        goex.USDT,
+		SupportCurrencyPairs: []goex.CurrencyPair{goex.BTC_USDT, goex.ETH_USDT},
+		Account: goex.Account{
+			SubAccounts: map[goex.Currency]goex.SubAccount{
+				goex.BTC:  {Currency: goex.BTC},
+				goex.ETH:  {Currency: goex.ETH},
+				goex.USDT: {Currency: goex.USDT, Amount: 10000},
+			},
+		},
+		BackTestStartTime: time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC),
+		BackTestEndTime:   time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC),
+		BackTestData:      model.BackTestDataType_Depth,
+		DepthSize:         1,
+	})
+	ws := NewSpotWsSim(ex)
+	assert.Equal(t, DepthCallbackNotSetError, ws.SubscribeDepth(goex.BTC_USDT))
+
+	var depths []*goex.Depth
+	var tickers []*goex.Ticker
+	var trades []*goex.Trade
+	ws.SetCallbacks(func(ticker *goex.Ticker) {
+		tickers = append(tickers, ticker)
+	}, func(depth *goex.Depth) {
+		depths = append(depths, depth)
+		if len(depths) == 1 {
+			//吃单立即成交,再挂一个卖单等后续行情成交
+			ex.LimitBuy("1", "7005", goex.BTC_USDT)
+			ex.LimitSell("1", "7015", goex.BTC_USDT)
+		}
+	}, func(trade *goex.Trade) {
+		trades = append(trades, trade)
+	}, nil)
+	assert.Nil(t, ws.SubscribeDepth(goex.BTC_USDT))
+	assert.Nil(t, ws.SubscribeTicker(goex.ETH_USDT))
+	assert.Nil(t, ws.SubscribeTrade(goex.BTC_USDT))
+	assert.Equal(t, KlineCallbackNotSetError, ws.SubscribeKline(goex.BTC_USDT, goex.KLINE_PERIOD_1MIN))
+
+	assert.Nil(t, ws.Run(context.Background()))
+
+	assert.Len(t, depths, 3)
+	assert.Equal(t, 7010.0, depths[1].BidList[0].Price)
+	assert.Len(t, tickers, 2)
+	assert.Equal(t, 189.0, tickers[1].Buy)
+	assert.Equal(t, uint64(1583971203000), tickers[1].Date)
+
+	assert.Len(t, trades, 2)
+	assert.Equal(t, goex.BUY, trades[0].Type)
+	assert.Equal(t, 7001.0, trades[0].Price)
+	assert.Equal(t, int64(1583971200000), trades[0].Date)
+	//挂单被动成交,推送的方向是taker方向
+	assert.Equal(t, goex.BUY, trades[1].Type)
+	assert.Equal(t, 7020.0, trades[1].Price)
+	assert.Equal(t, int64(1583971204000), trades[1].Date)
+}
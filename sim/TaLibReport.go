@@ -0,0 +1,201 @@
+package sim
+
+import (
+	"fmt"
+	"github.com/go-echarts/go-echarts/charts"
+	"github.com/markcheno/go-talib"
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_talib"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+var TaLibReportHtmlFile = "talib_report.html"
+
+//K线指标图配置,周期为0的指标不画
+type TaLibReportConfig struct {
+	Period        goex.KlinePeriod
+	MA            []int //简单均线周期
+	EMA           []int //指数均线周期
+	BollPeriod    int
+	BollDeviation float64
+	RSIPeriod     int
+	MACD          [3]int //快线、慢线、信号线周期
+}
+
+var DefaultTaLibReportConfig = TaLibReportConfig{
+	Period:        goex.KLINE_PERIOD_1MIN,
+	MA:            []int{7, 25},
+	BollPeriod:    20,
+	BollDeviation: 2,
+	RSIPeriod:     14,
+	MACD:          [3]int{12, 26, 9},
+}
+
+func (s *BacktestStatistics) SetTaLibReportConfig(config TaLibReportConfig) {
+	s.taLibConfig = config
+}
+
+//每个币对回测过的K线画成K线图,叠加均线、布林带和成交买卖点,RSI、MACD画在K线图下方
+func (s *BacktestStatistics) TaLibReport() {
+	page := charts.NewPage()
+	page.InitOpts.PageTitle = "指标"
+
+	engines := append([]*ExchangeSim{}, s.sims...)
+	for _, f := range s.futureSims {
+		engines = append(engines, f.engine)
+	}
+
+	var count int
+	for _, ex := range engines {
+		for _, pair := range ex.supportCurrencyPairs {
+			klines := ex.klineLoader.Loaded(pair, s.taLibConfig.Period)
+			if len(klines) == 0 {
+				continue
+			}
+			s.addTaLibCharts(page, ex, pair, klines)
+			count++
+		}
+	}
+	if count == 0 {
+		log.Println("[WARN] no kline data for the talib report")
+		return
+	}
+
+	reportF, err := os.OpenFile(TaLibReportHtmlFile, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0744)
+	if err != nil {
+		log.Printf("[ERROR] create the talib report file error=%s", err)
+		return
+	}
+	defer reportF.Close()
+	page.Render(reportF)
+}
+
+func (s *BacktestStatistics) addTaLibCharts(page *charts.Page, ex *ExchangeSim, pair goex.CurrencyPair, klines []goex.Kline) {
+	c := s.taLibConfig
+	title := fmt.Sprintf("%s %s", ex.GetExchangeName(), pair.ToSymbol("_"))
+
+	var (
+		xData  []string
+		kData  [][4]float64
+		closes []float64
+	)
+	for _, k := range klines {
+		xData = append(xData, time.Unix(klineTime(k)/1000, 0).Format("2006-01-02 15:04"))
+		kData = append(kData, [4]float64{k.Open, k.Close, k.Low, k.High})
+		closes = append(closes, k.Close)
+	}
+
+	//goex_talib的输入按时间从新到旧排列
+	reversed := make([]goex.Kline, len(klines))
+	for i, k := range klines {
+		reversed[len(klines)-1-i] = k
+	}
+
+	kline := charts.NewKLine()
+	kline.SetGlobalOptions(
+		charts.TitleOpts{Title: title},
+		charts.InitOpts{Width: "1080px", Height: "600px"},
+		charts.TooltipOpts{Show: true, Trigger: "axis"},
+		charts.YAxisOpts{SplitLine: charts.SplitLineOpts{Show: true}, Scale: true},
+		charts.DataZoomOpts{Type: "slider", Start: 0, End: 100},
+		charts.DataZoomOpts{Type: "inside", Start: 0, End: 100},
+	)
+	kline.AddXAxis(xData).AddYAxis("K线", kData)
+
+	overlay := charts.NewLine()
+	for _, period := range c.MA {
+		if period > 0 && period < len(klines) {
+			overlay.AddYAxis(fmt.Sprintf("MA%d", period),
+				indicatorSeries(goex_talib.Ma(reversed, period, talib.SMA, goex_talib.InClose), period-1))
+		}
+	}
+	for _, period := range c.EMA {
+		if period > 0 && period < len(klines) {
+			overlay.AddYAxis(fmt.Sprintf("EMA%d", period),
+				indicatorSeries(goex_talib.Ma(reversed, period, talib.EMA, goex_talib.InClose), period-1))
+		}
+	}
+	if c.BollPeriod > 0 && c.BollPeriod < len(klines) {
+		up, middle, low := goex_talib.Boll(reversed, c.BollPeriod, c.BollDeviation, goex_talib.InClose)
+		overlay.AddYAxis("BOLL_UP", indicatorSeries(up, c.BollPeriod-1))
+		overlay.AddYAxis("BOLL_MID", indicatorSeries(middle, c.BollPeriod-1))
+		overlay.AddYAxis("BOLL_LOW", indicatorSeries(low, c.BollPeriod-1))
+	}
+
+	buys, sells := fillMarkers(ex.GetFills(pair), klines, xData)
+	markers := charts.NewScatter()
+	markers.AddYAxis("买", buys, charts.ItemStyleOpts{Color: "#d14a61"})
+	markers.AddYAxis("卖", sells, charts.ItemStyleOpts{Color: "#3cb371"})
+
+	kline.Overlap(overlay, markers)
+	page.Add(kline)
+
+	if c.RSIPeriod > 0 && c.RSIPeriod < len(klines) {
+		rsi := charts.NewLine()
+		rsi.SetGlobalOptions(
+			charts.TitleOpts{Title: fmt.Sprintf("%s RSI%d", title, c.RSIPeriod)},
+			charts.InitOpts{Width: "1080px", Height: "250px"},
+			charts.TooltipOpts{Show: true, Trigger: "axis"},
+			charts.DataZoomOpts{Type: "inside", Start: 0, End: 100},
+		)
+		rsi.AddXAxis(xData).AddYAxis("RSI", indicatorSeries(talib.Rsi(closes, c.RSIPeriod), c.RSIPeriod))
+		page.Add(rsi)
+	}
+
+	fast, slow, signal := c.MACD[0], c.MACD[1], c.MACD[2]
+	if fast > 0 && slow > 0 && signal > 0 && slow+signal-2 < len(klines) {
+		lookback := slow + signal - 2
+		dif, dea, hist := goex_talib.Macd(reversed, fast, slow, signal, goex_talib.InClose)
+		macd := charts.NewLine()
+		macd.SetGlobalOptions(
+			charts.TitleOpts{Title: fmt.Sprintf("%s MACD(%d,%d,%d)", title, fast, slow, signal)},
+			charts.InitOpts{Width: "1080px", Height: "250px"},
+			charts.TooltipOpts{Show: true, Trigger: "axis"},
+			charts.DataZoomOpts{Type: "inside", Start: 0, End: 100},
+		)
+		macd.AddXAxis(xData).
+			AddYAxis("DIF", indicatorSeries(dif, lookback)).
+			AddYAxis("DEA", indicatorSeries(dea, lookback))
+		bar := charts.NewBar()
+		bar.AddYAxis("MACD", indicatorSeries(hist, lookback))
+		macd.Overlap(bar)
+		page.Add(macd)
+	}
+}
+
+//指标计算前lookback个值没有意义,用"-"表示缺失
+func indicatorSeries(values []float64, lookback int) []interface{} {
+	series := make([]interface{}, len(values))
+	for i, v := range values {
+		if i < lookback || math.IsNaN(v) {
+			series[i] = "-"
+		} else {
+			series[i] = v
+		}
+	}
+	return series
+}
+
+//成交点画在成交时间所在的K线上
+func fillMarkers(fills []Fill, klines []goex.Kline, xData []string) (buys, sells [][]interface{}) {
+	for _, fill := range fills {
+		idx := sort.Search(len(klines), func(i int) bool {
+			return klineTime(klines[i]) > fill.Timestamp
+		}) - 1
+		if idx < 0 {
+			continue
+		}
+
+		marker := []interface{}{xData[idx], fill.Price}
+		if fill.Side == goex.BUY || fill.Side == goex.BUY_MARKET {
+			buys = append(buys, marker)
+		} else {
+			sells = append(sells, marker)
+		}
+	}
+	return buys, sells
+}
@@ -0,0 +1,70 @@
+package sim
+
+import (
+	"fmt"
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBacktestStatistics_TaLibReport(t *testing.T) {
+	os.MkdirAll("data", 0755)
+	defer os.Remove("data")
+	var data strings.Builder
+	for i := 0; i < 40; i++ {
+		price := 7000 + float64(i%10)*10
+		fmt.Fprintf(&data, "%d,%f,%f,%f,%f,10\n", 1583971200+i*60, price+5, price-5, price, price)
+	}
+	fileName := "data/talib.test_kline_btcusdt_1min_2020-03-12.csv"
+	ioutil.WriteFile(fileName, []byte(data.String()), 0644)
+	defer os.Remove(fileName)
+	defer os.Remove(TaLibReportHtmlFile)
+
+	ex := newExchangeSim(model.ExchangeSimConfig{
+		ExName:               "talib.test",
+		QuoteCurrency:        goex.USDT,
+		SupportCurrencyPairs: []goex.CurrencyPair{goex.BTC_USDT},
+		Account: goex.Account{
+			SubAccounts: map[goex.Currency]goex.SubAccount{
+				goex.BTC:  {Currency: goex.BTC},
+				goex.USDT: {Currency: goex.USDT, Amount: 10000},
+			},
+		},
+		BackTestStartTime: time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC),
+		BackTestEndTime:   time.Date(2020, 03, 12, 0, 0, 0, 0, time.UTC),
+		BackTestData:      model.BackTestDataType_KLine,
+	})
+
+	for i := 0; i < 30; i++ {
+		ex.GetKlineRecords(goex.BTC_USDT, goex.KLINE_PERIOD_1MIN, 1)
+		if i == 3 {
+			ex.LimitBuy("0.1", "7100", goex.BTC_USDT)
+		}
+	}
+
+	klines := ex.klineLoader.Loaded(goex.BTC_USDT, goex.KLINE_PERIOD_1MIN)
+	assert.Len(t, klines, 30)
+	xData := make([]string, len(klines))
+	buys, sells := fillMarkers(ex.GetFills(goex.BTC_USDT), klines, xData)
+	assert.Len(t, buys, 1)
+	assert.Len(t, sells, 0)
+
+	s := NewBacktestStatistics([]*ExchangeSim{ex})
+	s.TaLibReport()
+	html, err := ioutil.ReadFile(TaLibReportHtmlFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(html), "MA25")
+	assert.Contains(t, string(html), "BOLL_UP")
+	assert.Contains(t, string(html), "RSI14")
+	//K线数量不够计算MACD
+	assert.NotContains(t, string(html), "MACD(")
+}
+
+func TestIndicatorSeries(t *testing.T) {
+	assert.Equal(t, []interface{}{"-", "-", 3.0}, indicatorSeries([]float64{0, 0, 3}, 2))
+}
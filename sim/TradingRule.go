@@ -0,0 +1,103 @@
+package sim
+
+import (
+	"errors"
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"math"
+)
+
+var (
+	InvalidPriceTickError  = errors.New("price does not match the price tick")
+	InvalidAmountStepError = errors.New("amount does not match the amount step")
+	AmountTooSmallError    = errors.New("amount less than the minimum amount")
+	AmountTooLargeError    = errors.New("amount greater than the maximum amount")
+	NotionalTooSmallError  = errors.New("notional less than the minimum notional")
+)
+
+func newTradingRules(rules map[string]model.TradingRule) map[string]model.TradingRule {
+	tradingRules := make(map[string]model.TradingRule, len(rules))
+	for pair, rule := range rules {
+		tradingRules[goex.NewCurrencyPair2(pair).ToSymbol("_")] = rule
+	}
+	return tradingRules
+}
+
+//按交易规则检查订单,与交易所一样拒绝不符合精度和最小下单量的订单
+func (ex *ExchangeSim) checkTradingRule(ord goex.Order) error {
+	rule, ok := ex.tradingRules[ord.Currency.ToSymbol("_")]
+	if !ok {
+		return nil
+	}
+
+	if isMarketBuy(ord) { //市价买单的数量是计价币金额,按最新价估算的成交数量也要符合最小、最大下单量
+		if ord.Amount < rule.MinNotional {
+			return NotionalTooSmallError
+		}
+		price := ex.lastPrice(ord.Currency)
+		if price <= 0 {
+			return NoMarketPriceError
+		}
+		amount := ex.floorToStep(ord.Currency, ord.Amount/price)
+		if amount < rule.MinAmount || amount <= 0 {
+			return AmountTooSmallError
+		}
+		if rule.MaxAmount > 0 && amount > rule.MaxAmount {
+			return AmountTooLargeError
+		}
+		return nil
+	}
+
+	if execType(ord) != "market" && !onStep(ord.Price, rule.PriceTick) {
+		return InvalidPriceTickError
+	}
+	if !onStep(ord.Amount, rule.AmountStep) {
+		return InvalidAmountStepError
+	}
+	if ord.Amount < rule.MinAmount {
+		return AmountTooSmallError
+	}
+	if rule.MaxAmount > 0 && ord.Amount > rule.MaxAmount {
+		return AmountTooLargeError
+	}
+
+	price := ord.Price
+	if execType(ord) == "market" {
+		price = ex.lastPrice(ord.Currency)
+		if price <= 0 {
+			return NoMarketPriceError
+		}
+	}
+	if ord.Amount*price < rule.MinNotional {
+		return NotionalTooSmallError
+	}
+
+	return nil
+}
+
+//value是否为step的整数倍,step为0不限制
+func onStep(value, step float64) bool {
+	if step <= 0 {
+		return true
+	}
+	n := value / step
+	return math.Abs(n-math.Round(n)) <= 1e-6
+}
+
+//按交易规则的价格精度取整
+func (ex *ExchangeSim) roundToTick(pair goex.CurrencyPair, price float64) float64 {
+	rule, ok := ex.tradingRules[pair.ToSymbol("_")]
+	if !ok || rule.PriceTick <= 0 {
+		return price
+	}
+	return math.Round(price/rule.PriceTick) * rule.PriceTick
+}
+
+//按交易规则的数量精度向下取整,市价买单按计价币金额成交时成交数量也要符合数量精度
+func (ex *ExchangeSim) floorToStep(pair goex.CurrencyPair, amount float64) float64 {
+	rule, ok := ex.tradingRules[pair.ToSymbol("_")]
+	if !ok || rule.AmountStep <= 0 {
+		return amount
+	}
+	return math.Floor(amount/rule.AmountStep+1e-6) * rule.AmountStep
+}
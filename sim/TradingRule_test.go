@@ -0,0 +1,89 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExchangeSim_TradingRule(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+	ex.tradingRules = newTradingRules(map[string]model.TradingRule{
+		"btc_usdt": {PriceTick: 0.01, AmountStep: 0.000001, MinAmount: 0.0001, MaxAmount: 100, MinNotional: 10},
+	})
+
+	_, err := ex.LimitBuy("0.1", "6990.001", goex.BTC_USDT)
+	assert.Equal(t, InvalidPriceTickError, err)
+
+	_, err = ex.LimitBuy("0.0000001", "6990", goex.BTC_USDT)
+	assert.Equal(t, InvalidAmountStepError, err)
+
+	_, err = ex.LimitBuy("0.00001", "6990", goex.BTC_USDT)
+	assert.Equal(t, AmountTooSmallError, err)
+
+	_, err = ex.LimitSell("101", "7010", goex.BTC_USDT)
+	assert.Equal(t, AmountTooLargeError, err)
+
+	_, err = ex.LimitBuy("0.001", "6990", goex.BTC_USDT)
+	assert.Equal(t, NotionalTooSmallError, err)
+
+	_, err = ex.MarketBuy("5", "", goex.BTC_USDT)
+	assert.Equal(t, NotionalTooSmallError, err)
+
+	ord, err := ex.LimitBuy("0.123456", "6990.12", goex.BTC_USDT)
+	assert.Nil(t, err)
+	assert.Equal(t, goex.ORDER_UNFINISH, ord.Status)
+
+	//被拒绝的订单不冻结资产
+	acc, _ := ex.GetAccount()
+	assert.InDelta(t, 0.123456*6990.12, acc.SubAccounts[goex.USDT].ForzenAmount, 1e-8)
+}
+
+func TestExchangeSim_TradingRule_NoMarketPrice(t *testing.T) {
+	ex := NewExchangeSim(model.ExchangeSimConfig{
+		ExName:               goex.BINANCE,
+		QuoteCurrency:        goex.USDT,
+		SupportCurrencyPairs: []goex.CurrencyPair{goex.BTC_USDT},
+		Account: goex.Account{
+			SubAccounts: map[goex.Currency]goex.SubAccount{
+				goex.BTC:  {Currency: goex.BTC, Amount: 1},
+				goex.USDT: {Currency: goex.USDT, Amount: 100000},
+			},
+		},
+		BackTestData: model.BackTestDataType_Depth,
+		TradingRules: map[string]model.TradingRule{"btc_usdt": {AmountStep: 0.001, MinNotional: 10}},
+	})
+
+	//没有行情时不是名义价值不足
+	_, err := ex.MarketSell("0.5", "", goex.BTC_USDT)
+	assert.Equal(t, NoMarketPriceError, err)
+
+	_, err = ex.MarketBuy("100", "", goex.BTC_USDT)
+	assert.Equal(t, NoMarketPriceError, err)
+}
+
+func TestExchangeSim_TradingRule_MarketBuyAmount(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+	ex.tradingRules = newTradingRules(map[string]model.TradingRule{
+		"btc_usdt": {AmountStep: 0.001, MinAmount: 0.01, MaxAmount: 1, MinNotional: 10},
+	})
+
+	//按最新价估算的成交数量检查最小、最大下单量
+	_, err := ex.MarketBuy("50", "", goex.BTC_USDT)
+	assert.Equal(t, AmountTooSmallError, err)
+
+	_, err = ex.MarketBuy("8000", "", goex.BTC_USDT)
+	assert.Equal(t, AmountTooLargeError, err)
+
+	//成交数量按数量精度取整,剩余不够买一个精度的金额退回
+	ord, err := ex.MarketBuy("5250", "", goex.BTC_USDT)
+	assert.Nil(t, err)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.InDelta(t, 0.749, ord.DealAmount, 1e-9)
+	assert.True(t, onStep(ord.DealAmount, 0.001))
+
+	acc, _ := ex.GetAccount()
+	assert.InDelta(t, 100000-0.5*7001-0.249*7002, acc.SubAccounts[goex.USDT].Amount, 1e-6)
+	assert.InDelta(t, 0, acc.SubAccounts[goex.USDT].ForzenAmount, 1e-6)
+}
@@ -0,0 +1,229 @@
+package sim
+
+import (
+	"errors"
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/nntaoli-project/goex_backtest/util"
+	"math"
+	"sort"
+)
+
+var (
+	InvalidTriggerOrderError = errors.New("invalid trigger order")
+	TriggerImmediatelyError  = errors.New("order would immediately trigger")
+	triggerOrderTypeNames    = map[model.TriggerOrderType]string{
+		model.TriggerOrderType_StopMarket:       "stop_market",
+		model.TriggerOrderType_StopLimit:        "stop_limit",
+		model.TriggerOrderType_TakeProfitMarket: "take_profit_market",
+		model.TriggerOrderType_TakeProfitLimit:  "take_profit_limit",
+		model.TriggerOrderType_TrailingStop:     "trailing_stop",
+	}
+	triggerExecTypes = map[string]string{
+		"stop_market":        "market",
+		"stop_limit":         "limit",
+		"take_profit_market": "market",
+		"take_profit_limit":  "limit",
+		"trailing_stop":      "market",
+	}
+)
+
+//条件单,触发前不冻结资产,触发后按同一订单号的限价单或市价单撮合,订单Type保持条件单类型
+type triggerOrder struct {
+	param   model.TriggerOrderParameter
+	ord     *goex.Order
+	extreme float64 //跟踪止损单下单以来的最高价(卖)/最低价(买)
+}
+
+//价格向下穿过触发价时触发
+func (t *triggerOrder) triggerBelow() bool {
+	switch t.param.Type {
+	case model.TriggerOrderType_TakeProfitMarket, model.TriggerOrderType_TakeProfitLimit:
+		return t.param.Side == goex.BUY
+	default:
+		return t.param.Side == goex.SELL
+	}
+}
+
+//当前的触发价
+func (t *triggerOrder) level() float64 {
+	if t.param.Type != model.TriggerOrderType_TrailingStop {
+		return t.param.TriggerPrice
+	}
+	if t.param.Side == goex.SELL {
+		return t.extreme * (1 - t.param.CallbackRate)
+	}
+	return t.extreme * (1 + t.param.CallbackRate)
+}
+
+func (t *triggerOrder) triggered(price float64) bool {
+	if t.triggerBelow() {
+		return price <= t.level()
+	}
+	return price >= t.level()
+}
+
+//更新跟踪止损单的极值价格
+func (t *triggerOrder) update(price float64) {
+	if t.param.Type != model.TriggerOrderType_TrailingStop {
+		return
+	}
+	if t.param.Side == goex.SELL {
+		t.extreme = math.Max(t.extreme, price)
+	} else {
+		t.extreme = math.Min(t.extreme, price)
+	}
+}
+
+func (t *triggerOrder) isLimit() bool {
+	return t.param.Type == model.TriggerOrderType_StopLimit || t.param.Type == model.TriggerOrderType_TakeProfitLimit
+}
+
+//条件单:止损、止盈、跟踪止损,在每次行情更新时按最新价检查是否触发
+func (ex *ExchangeSim) PlaceTriggerOrder(currency goex.CurrencyPair, param model.TriggerOrderParameter) (*goex.Order, error) {
+	defer ex.flushOrderEvents()
+	ex.Lock()
+	defer ex.Unlock()
+
+	typeName, ok := triggerOrderTypeNames[param.Type]
+	if !ok || param.Amount <= 0 || (param.Side != goex.BUY && param.Side != goex.SELL) {
+		return nil, InvalidTriggerOrderError
+	}
+
+	price := ex.lastPrice(currency)
+	if price <= 0 { //还没有行情时无法判断是否立即触发,跟踪止损也没有起始价
+		return nil, NoMarketPriceError
+	}
+
+	t := &triggerOrder{param: param, extreme: price}
+	switch {
+	case param.Type == model.TriggerOrderType_TrailingStop:
+		if param.CallbackRate <= 0 || param.CallbackRate >= 1 {
+			return nil, InvalidTriggerOrderError
+		}
+	case param.TriggerPrice <= 0, t.isLimit() && param.Price <= 0:
+		return nil, InvalidTriggerOrderError
+	case t.triggered(price):
+		return nil, TriggerImmediatelyError
+	}
+
+	t.ord = &goex.Order{
+		Amount:    param.Amount,
+		OrderID2:  ex.idGen.Get(),
+		OrderTime: int(ex.now()),
+		Status:    goex.ORDER_UNFINISH,
+		Currency:  currency,
+		Side:      param.Side,
+		Type:      typeName,
+	}
+	if t.isLimit() {
+		t.ord.Price = param.Price
+	}
+
+	if err := ex.checkTradingRule(*t.ord); err != nil { //按触发后的撮合方式检查交易规则
+		return nil, err
+	}
+
+	ex.triggerOrders[t.ord.OrderID2] = t
+	ex.emitOrderEvent(OrderEventType_New, t.ord)
+	ex.delayRequest(t.ord, false)
+
+	var result goex.Order
+	util.DeepCopyStruct(*t.ord, &result)
+
+	return &result, nil
+}
+
+//订单的撮合方式,"market"或"limit",条件单为触发后的方式
+func execType(ord goex.Order) string {
+	if typ, ok := triggerExecTypes[ord.Type]; ok {
+		return typ
+	}
+	return ord.Type
+}
+
+//币对的最新价,深度回测为盘口中间价
+func (ex *ExchangeSim) lastPrice(pair goex.CurrencyPair) float64 {
+	if ex.backTestDataType == model.BackTestDataType_KLine {
+		return ex.klineQuote(pair)
+	}
+	depth := ex.market(pair).depth
+	if len(depth.AskList) == 0 || len(depth.BidList) == 0 {
+		return 0
+	}
+	return (depth.AskList[len(depth.AskList)-1].Price + depth.BidList[0].Price) / 2
+}
+
+//检查条件单,K线回测沿K线内部价格路径逐段检查,触发后以触发价作为市价单的成交参考价
+func (ex *ExchangeSim) matchTriggerOrders() {
+	if len(ex.triggerOrders) == 0 {
+		return
+	}
+
+	for _, t := range ex.sortedTriggerOrders() {
+		if ex.unarrived[t.ord.OrderID2] {
+			continue
+		}
+
+		path := []float64{ex.lastPrice(t.ord.Currency)}
+		if ex.backTestDataType == model.BackTestDataType_KLine {
+			k := ex.market(t.ord.Currency).kline
+			if int64(t.ord.OrderTime) >= klineTime(k) { //下单前已走完的K线
+				continue
+			}
+			path = ex.klinePricePath(k)
+		}
+
+		for i, price := range path {
+			if float64(i) > ex.pathLimit {
+				break
+			}
+			if t.triggered(price) {
+				touch := price //开盘跳空越过触发价时以开盘价成交
+				if i > 0 && !t.triggered(path[i-1]) {
+					touch = t.level()
+				}
+				ex.fireTriggerOrder(t, touch)
+				break
+			}
+			t.update(price)
+		}
+	}
+}
+
+func (ex *ExchangeSim) sortedTriggerOrders() []*triggerOrder {
+	orders := make([]*triggerOrder, 0, len(ex.triggerOrders))
+	for _, t := range ex.triggerOrders {
+		orders = append(orders, t)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].ord.OrderTime != orders[j].ord.OrderTime {
+			return orders[i].ord.OrderTime < orders[j].ord.OrderTime
+		}
+		if len(orders[i].ord.OrderID2) != len(orders[j].ord.OrderID2) {
+			return len(orders[i].ord.OrderID2) < len(orders[j].ord.OrderID2)
+		}
+		return orders[i].ord.OrderID2 < orders[j].ord.OrderID2
+	})
+	return orders
+}
+
+//触发:冻结资产后转为普通订单撮合,资产不足时拒绝
+func (ex *ExchangeSim) fireTriggerOrder(t *triggerOrder, touch float64) {
+	delete(ex.triggerOrders, t.ord.OrderID2)
+
+	ord := t.ord
+	if err := ex.ledger.frozenAsset(*ord); err != nil {
+		ord.Status = goex.ORDER_REJECT
+		ord.FinishedTime = ex.now()
+		ex.finishOrder(ord)
+		ex.emitOrderEvent(OrderEventType_Rejected, ord)
+		return
+	}
+
+	m := ex.market(ord.Currency)
+	ex.pendingOrders[ord.OrderID2] = ord
+	m.klineQuotePrice = touch
+	ex.submitOrder(ord)
+	m.klineQuotePrice = 0
+}
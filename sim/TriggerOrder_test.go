@@ -0,0 +1,161 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExchangeSim_StopMarket(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_Depth)
+	depth := ex.market(goex.BTC_USDT).depth
+
+	ord, err := ex.PlaceTriggerOrder(goex.BTC_USDT, model.TriggerOrderParameter{
+		Type:         model.TriggerOrderType_StopMarket,
+		Side:         goex.SELL,
+		Amount:       0.5,
+		TriggerPrice: 6995,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "stop_market", ord.Type)
+	assert.Equal(t, goex.ORDER_UNFINISH, ord.Status)
+
+	//触发前不冻结资产
+	acc, _ := ex.GetAccount()
+	assert.Equal(t, 0.0, acc.SubAccounts[goex.BTC].ForzenAmount)
+	orders, _ := ex.GetUnfinishOrders(goex.BTC_USDT)
+	assert.Len(t, orders, 1)
+
+	depth.AskList = goex.DepthRecords{{Price: 6993, Amount: 1}, {Price: 6992, Amount: 1}}
+	depth.BidList = goex.DepthRecords{{Price: 6990, Amount: 1}, {Price: 6989, Amount: 1}}
+	ex.updateDepth(depth)
+
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.Equal(t, "stop_market", ord.Type)
+	assert.Equal(t, 6990.0, ord.AvgPrice)
+
+	orders, _ = ex.GetOrderHistorys(goex.BTC_USDT)
+	assert.Len(t, orders, 1)
+	assert.Equal(t, "stop_market", orders[0].Type)
+}
+
+func TestExchangeSim_StopLimit_KLine(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_KLine)
+
+	ord, err := ex.PlaceTriggerOrder(goex.BTC_USDT, model.TriggerOrderParameter{
+		Type:         model.TriggerOrderType_StopLimit,
+		Side:         goex.BUY,
+		Amount:       0.1,
+		Price:        7006,
+		TriggerPrice: 7005,
+	})
+	assert.Nil(t, err)
+
+	//开-高-低-收路径上,价格在上涨到7005时触发,以触发价成交
+	ex.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 7001, High: 7010, Low: 6995, Close: 7008, Vol: 10})
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.Equal(t, "stop_limit", ord.Type)
+	assert.Equal(t, 7005.0, ord.AvgPrice)
+}
+
+func TestExchangeSim_TakeProfit_Gap(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_KLine)
+
+	ord, _ := ex.PlaceTriggerOrder(goex.BTC_USDT, model.TriggerOrderParameter{
+		Type:         model.TriggerOrderType_TakeProfitMarket,
+		Side:         goex.SELL,
+		Amount:       0.1,
+		TriggerPrice: 7050,
+	})
+
+	//跳空高开越过触发价,以开盘价成交
+	ex.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 7100, High: 7110, Low: 7090, Close: 7095, Vol: 10})
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.InDelta(t, 7100*(1-ex.marketOrderSlippage), ord.AvgPrice, 1e-8)
+}
+
+func TestExchangeSim_TrailingStop(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_KLine)
+
+	ord, err := ex.PlaceTriggerOrder(goex.BTC_USDT, model.TriggerOrderParameter{
+		Type:         model.TriggerOrderType_TrailingStop,
+		Side:         goex.SELL,
+		Amount:       0.1,
+		CallbackRate: 0.01,
+	})
+	assert.Nil(t, err)
+
+	ex.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 7000, High: 7050, Low: 7000, Close: 7040, Vol: 10})
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_UNFINISH, ord.Status)
+
+	//最高价7100,回调1%到7029触发
+	ex.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971320, Open: 7040, High: 7100, Low: 7020, Close: 7050, Vol: 10})
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_FINISH, ord.Status)
+	assert.InDelta(t, 7029*(1-ex.marketOrderSlippage), ord.AvgPrice, 1e-8)
+}
+
+func TestExchangeSim_TriggerOrder_CancelAndReject(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_KLine)
+
+	_, err := ex.PlaceTriggerOrder(goex.BTC_USDT, model.TriggerOrderParameter{
+		Type: model.TriggerOrderType_StopMarket, Side: goex.SELL, Amount: 0.1, TriggerPrice: 7100,
+	})
+	assert.Equal(t, TriggerImmediatelyError, err)
+
+	_, err = ex.PlaceTriggerOrder(goex.BTC_USDT, model.TriggerOrderParameter{
+		Type: model.TriggerOrderType_StopLimit, Side: goex.SELL, Amount: 0.1, TriggerPrice: 6900,
+	})
+	assert.Equal(t, InvalidTriggerOrderError, err)
+
+	ord, _ := ex.PlaceTriggerOrder(goex.BTC_USDT, model.TriggerOrderParameter{
+		Type: model.TriggerOrderType_StopMarket, Side: goex.SELL, Amount: 0.1, TriggerPrice: 6900,
+	})
+	ok, err := ex.CancelOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	_, err = ex.CancelOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, CancelOrderFinishedError, err)
+
+	//触发时资产不足
+	ord, _ = ex.PlaceTriggerOrder(goex.BTC_USDT, model.TriggerOrderParameter{
+		Type: model.TriggerOrderType_StopMarket, Side: goex.SELL, Amount: 2, TriggerPrice: 6900,
+	})
+	ex.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 7000, High: 7000, Low: 6800, Close: 6850, Vol: 10})
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_REJECT, ord.Status)
+}
+
+func TestExchangeSim_TriggerOrder_NoMarketPrice(t *testing.T) {
+	ex := newExchangeSim(model.ExchangeSimConfig{
+		ExName:               goex.BINANCE,
+		QuoteCurrency:        goex.USDT,
+		SupportCurrencyPairs: []goex.CurrencyPair{goex.BTC_USDT},
+		Account: goex.Account{
+			SubAccounts: map[goex.Currency]goex.SubAccount{
+				goex.USDT: {Currency: goex.USDT, Amount: 100000},
+			},
+		},
+		BackTestData: model.BackTestDataType_KLine,
+	})
+
+	//第一个行情之前下的跟踪止损单没有起始价,不能以0为最低价立即触发
+	_, err := ex.PlaceTriggerOrder(goex.BTC_USDT, model.TriggerOrderParameter{
+		Type: model.TriggerOrderType_TrailingStop, Side: goex.BUY, Amount: 0.1, CallbackRate: 0.01,
+	})
+	assert.Equal(t, NoMarketPriceError, err)
+
+	ex.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971200, Open: 7000, High: 7010, Low: 6990, Close: 7000, Vol: 10})
+	ord, err := ex.PlaceTriggerOrder(goex.BTC_USDT, model.TriggerOrderParameter{
+		Type: model.TriggerOrderType_TrailingStop, Side: goex.BUY, Amount: 0.1, CallbackRate: 0.01,
+	})
+	assert.Nil(t, err)
+	ex.updateKline(goex.Kline{Pair: goex.BTC_USDT, Timestamp: 1583971260, Open: 7000, High: 7020, Low: 6995, Close: 7010, Vol: 10})
+	ord, _ = ex.GetOneOrder(ord.OrderID2, goex.BTC_USDT)
+	assert.Equal(t, goex.ORDER_UNFINISH, ord.Status)
+}
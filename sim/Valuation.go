@@ -0,0 +1,51 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+)
+
+//币种按净值币种估值的价格,通过有行情的币对逐级换算,如ETH→BTC→USDT
+//行情价取买一价,经币对反向换算(1/price)时相当于以买一价买入,会略微高估计价币一侧的持仓
+func (ex *ExchangeSim) valuationPrice(currency goex.Currency) (float64, bool) {
+	if currency.Eq(ex.quoteCurrency) {
+		return 1, true
+	}
+
+	prices := map[string]float64{currency.Symbol: 1} //1个currency等于多少个key币种
+	queue := []goex.Currency{currency}
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+
+		for _, pair := range ex.supportCurrencyPairs {
+			price := ex.lastPrices[pair.ToSymbol("_")]
+			if price <= 0 {
+				continue
+			}
+
+			var (
+				next goex.Currency
+				rate float64
+			)
+			switch {
+			case pair.CurrencyA.Eq(curr):
+				next, rate = pair.CurrencyB, price
+			case pair.CurrencyB.Eq(curr):
+				next, rate = pair.CurrencyA, 1/price
+			default:
+				continue
+			}
+			if _, ok := prices[next.Symbol]; ok {
+				continue
+			}
+
+			prices[next.Symbol] = prices[curr.Symbol] * rate
+			if next.Eq(ex.quoteCurrency) {
+				return prices[next.Symbol], true
+			}
+			queue = append(queue, next)
+		}
+	}
+
+	return 0, false
+}
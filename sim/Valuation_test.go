@@ -0,0 +1,34 @@
+package sim
+
+import (
+	"github.com/nntaoli-project/goex"
+	"github.com/nntaoli-project/goex_backtest/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExchangeSim_ValuationPrice(t *testing.T) {
+	ex := newTestExchangeSim(model.BackTestDataType_KLine)
+	ex.supportCurrencyPairs = []goex.CurrencyPair{goex.BTC_USDT, goex.ETH_BTC, goex.LTC_BTC}
+
+	_, ok := ex.valuationPrice(goex.ETH)
+	assert.False(t, ok)
+
+	ex.updateKline(goex.Kline{Pair: goex.ETH_BTC, Timestamp: 1583971200, Open: 0.02, High: 0.02, Low: 0.02, Close: 0.02, Vol: 10})
+
+	price, ok := ex.valuationPrice(goex.ETH)
+	assert.True(t, ok)
+	assert.InDelta(t, 140.0, price, 1e-9)
+
+	price, _ = ex.valuationPrice(goex.USDT)
+	assert.Equal(t, 1.0, price)
+
+	//以BTC为净值币种时反向换算
+	ex.quoteCurrency = goex.BTC
+	price, ok = ex.valuationPrice(goex.USDT)
+	assert.True(t, ok)
+	assert.InDelta(t, 1/7000.0, price, 1e-12)
+
+	_, ok = ex.valuationPrice(goex.LTC)
+	assert.False(t, ok)
+}
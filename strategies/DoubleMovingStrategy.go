@@ -1,11 +1,9 @@
 package strategies
 
 import (
-	"context"
 	"fmt"
 	"github.com/markcheno/go-talib"
 	"github.com/nntaoli-project/goex"
-	"github.com/nntaoli-project/goex_backtest/sim"
 	"github.com/nntaoli-project/goex_talib"
 	"log"
 )
@@ -13,53 +11,68 @@ import (
 type DoubleMovingStrategy struct {
 	api    goex.API
 	pair   goex.CurrencyPair
-	period goex.KlinePeriod
 	long   int
 	short  int
+	klines []goex.Kline //当前批次的K线,最新的在前
 
 	holdOder *goex.Order
 }
 
-func NewDoubleMovingStrategy(api goex.API,
-	period goex.KlinePeriod,
-	long, short int,
-	pair goex.CurrencyPair) *DoubleMovingStrategy {
+func NewDoubleMovingStrategy(long, short int, pair goex.CurrencyPair) *DoubleMovingStrategy {
 	return &DoubleMovingStrategy{
-		api:    api,
-		pair:   pair,
-		period: period,
-		long:   long,
-		short:  short,
+		pair:  pair,
+		long:  long,
+		short: short,
 	}
 }
 
-func (s *DoubleMovingStrategy) Main(ctx context.Context) {
-	api := s.api.(*sim.ExchangeSim)
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			klineData, err := s.api.GetKlineRecords(s.pair, s.period, s.long)
+func (s *DoubleMovingStrategy) OnStart(api goex.API) {
+	s.api = api
+}
+
+func (s *DoubleMovingStrategy) OnKline(kline goex.Kline) {
+	if !kline.Pair.Eq(s.pair) {
+		return
+	}
+
+	//与原先每次取long根K线一致,每凑满一批互不重叠的long根K线判断一次
+	s.klines = append([]goex.Kline{kline}, s.klines...)
+	if len(s.klines) < s.long {
+		return
+	}
+
+	longValue := goex_talib.Ma(s.klines, s.long, talib.EMA, goex_talib.InClose)
+	shortValue := goex_talib.Ma(s.klines, s.short, talib.EMA, goex_talib.InClose)
+	s.klines = nil
+	if shortValue[len(shortValue)-1] > longValue[len(longValue)-1] {
+		if s.holdOder == nil {
+			ord, err := s.api.LimitBuy("0.4", fmt.Sprint(kline.Close), s.pair)
 			if err != nil {
+				log.Println("[ERROR] ", err)
 				return
 			}
-			longValue := goex_talib.Ma(klineData, s.long, talib.EMA, goex_talib.InClose)
-			shortValue := goex_talib.Ma(klineData, s.short, talib.EMA, goex_talib.InClose)
-			if shortValue[len(shortValue)-1] > longValue[len(longValue)-1] {
-				if s.holdOder == nil {
-					s.holdOder, _ = api.LimitBuy("0.4", fmt.Sprint(klineData[0].Close), s.pair)
-					api.AssetSnapshot()
-					log.Printf("[开仓] 短期均线上穿长期均线,短期%d均线值:%f,长期%d均线值:%f,开仓价:%f", s.short, shortValue[len(shortValue)-1], s.long, longValue[len(longValue)-1], s.holdOder.Price)
-				}
-			} else {
-				if s.holdOder != nil {
-					ord, _ := api.LimitSell(fmt.Sprint(s.holdOder.Amount), fmt.Sprint(klineData[0].Close), s.pair)
-					api.AssetSnapshot()
-					log.Printf("[平仓] 短期均线下穿长期均线,短期%d均线值:%f,长期%d均线值:%f,平仓价:%f", s.short, shortValue[len(shortValue)-1], s.long, longValue[len(longValue)-1], ord.Price)
-					s.holdOder = nil
-				}
+			s.holdOder = ord
+			log.Printf("[开仓] 短期均线上穿长期均线,短期%d均线值:%f,长期%d均线值:%f,开仓价:%f", s.short, shortValue[len(shortValue)-1], s.long, longValue[len(longValue)-1], s.holdOder.Price)
+		}
+	} else {
+		if s.holdOder != nil {
+			ord, err := s.api.LimitSell(fmt.Sprint(s.holdOder.Amount), fmt.Sprint(kline.Close), s.pair)
+			if err != nil {
+				log.Println("[ERROR] ", err)
+				return
 			}
+			log.Printf("[平仓] 短期均线下穿长期均线,短期%d均线值:%f,长期%d均线值:%f,平仓价:%f", s.short, shortValue[len(shortValue)-1], s.long, longValue[len(longValue)-1], ord.Price)
+			s.holdOder = nil
 		}
 	}
 }
+
+func (s *DoubleMovingStrategy) OnDepth(depth goex.Depth) {
+}
+
+func (s *DoubleMovingStrategy) OnOrderUpdate(order goex.Order) {
+	log.Printf("[订单] %s %s 状态:%v 成交:%f 均价:%f", order.OrderID2, order.Side, order.Status, order.DealAmount, order.AvgPrice)
+}
+
+func (s *DoubleMovingStrategy) OnFinish() {
+}
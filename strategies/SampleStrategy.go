@@ -1,131 +1,116 @@
 package strategies
 
 import (
-	"context"
 	"fmt"
 	"github.com/nntaoli-project/goex"
 	"log"
 )
 
 type SampleStragtegy struct {
-	api goex.API
+	api        goex.API
+	buyOrd     *goex.Order
+	sellOrd    *goex.Order
+	amount     float64
+	sellCount  int
+	buyCount   int
+	sellOffset float64
+	buyOffset  float64
 }
 
-func NewSampleStrategy(api goex.API) *SampleStragtegy {
-	return &SampleStragtegy{api: api}
+func NewSampleStrategy() *SampleStragtegy {
+	return &SampleStragtegy{
+		amount:     0.01,
+		sellOffset: 0.2,
+		buyOffset:  0.2,
+	}
 }
 
-func (s *SampleStragtegy) Main(ctx context.Context) {
-	var (
-		buyOrd     *goex.Order
-		ord        *goex.Order
-		amount     = 0.01
-		sellCount  = 0
-		buyCount   = 0
-		sellOffset = 0.2
-		buyOffset  = 0.2
-	)
-
-	defer func() {
-		log.Println("=========== 买:", buyCount, "==========")
-		log.Println("=========== 卖:", sellCount, "==========")
-	}()
+func (s *SampleStragtegy) OnStart(api goex.API) {
+	s.api = api
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			dep, err := s.api.GetDepth(1, goex.BTC_USDT)
-			if err != nil && err.Error() == "depth data finished" {
-				log.Println("========== finished ==========")
-				return
-			}
+func (s *SampleStragtegy) OnKline(kline goex.Kline) {
+}
 
-			askItem := dep.AskList[len(dep.AskList)-1]
-			bidItem := dep.BidList[0]
-			if askItem.Price <= 0 || askItem.Amount <= 0 || bidItem.Price <= 0 || bidItem.Amount <= 0 {
-				//	log.Println(dep)
-				continue
-			}
+func (s *SampleStragtegy) OnDepth(dep goex.Depth) {
+	if !dep.Pair.Eq(goex.BTC_USDT) || len(dep.AskList) == 0 || len(dep.BidList) == 0 {
+		return
+	}
 
-			acc, _ := s.api.GetAccount()
-			btcAcc := acc.SubAccounts[goex.BTC]
-			usdtAcc := acc.SubAccounts[goex.USDT]
+	askItem := dep.AskList[len(dep.AskList)-1]
+	bidItem := dep.BidList[0]
+	if askItem.Price <= 0 || askItem.Amount <= 0 || bidItem.Price <= 0 || bidItem.Amount <= 0 {
+		//	log.Println(dep)
+		return
+	}
 
-			if ord == nil {
-				if btcAcc.Amount < amount {
-					goto buy
-				}
+	acc, _ := s.api.GetAccount()
+	btcAcc := acc.SubAccounts[goex.BTC]
+	usdtAcc := acc.SubAccounts[goex.USDT]
 
-				ord, err = s.api.LimitSell(fmt.Sprint(amount), fmt.Sprint(dep.AskList[len(dep.AskList)-1].Price), goex.BTC_USDT)
-				if err != nil {
-					log.Println("[ERROR] ", err)
-				} else {
-					log.Println(ord)
-				}
+	if s.sellOrd == nil {
+		if btcAcc.Amount >= s.amount {
+			ord, err := s.api.LimitSell(fmt.Sprint(s.amount), fmt.Sprint(askItem.Price), goex.BTC_USDT)
+			if err != nil {
+				log.Println("[ERROR] ", err)
 			} else {
-				_ord, err := s.api.GetOneOrder(ord.OrderID2, ord.Currency)
-				if err != nil {
-					panic(err)
-				}
-				ord = _ord
-			}
-
-			if ord != nil && ord.Status == goex.ORDER_FINISH {
 				log.Println(ord)
-				sellCount++
-				ord = nil //reset
-			} else if ord != nil {
-				offset := (ord.Price - dep.BidList[0].Price) / ord.Price * 100
-				if offset >= sellOffset {
-					_, err := s.api.CancelOrder(ord.OrderID2, goex.BTC_USDT)
-					if err != nil {
-						log.Println("[ERROR] cancel error=", err)
-						log.Println(s.api.GetOneOrder(ord.OrderID2, goex.BTC_USDT))
-					}
-					ord = nil
-				}
+				s.sellOrd = ord
 			}
+		}
+	} else {
+		offset := (s.sellOrd.Price - bidItem.Price) / s.sellOrd.Price * 100
+		if offset >= s.sellOffset {
+			_, err := s.api.CancelOrder(s.sellOrd.OrderID2, goex.BTC_USDT)
+			if err != nil {
+				log.Println("[ERROR] cancel error=", err)
+				log.Println(s.api.GetOneOrder(s.sellOrd.OrderID2, goex.BTC_USDT))
+			}
+			s.sellOrd = nil
+		}
+	}
 
-		buy:
-			if buyOrd == nil {
-				if usdtAcc.Amount < dep.BidList[0].Price*amount {
-					goto end
-				}
-
-				buyOrd, err = s.api.LimitBuy(fmt.Sprint(amount), fmt.Sprintf("%.2f", dep.BidList[0].Price), goex.BTC_USDT)
-				if err != nil {
-					log.Println("[ERROR] ", err)
-				} else {
-					log.Println(buyOrd)
-				}
+	if s.buyOrd == nil {
+		if usdtAcc.Amount >= bidItem.Price*s.amount {
+			ord, err := s.api.LimitBuy(fmt.Sprint(s.amount), fmt.Sprintf("%.2f", bidItem.Price), goex.BTC_USDT)
+			if err != nil {
+				log.Println("[ERROR] ", err)
 			} else {
-				_ord, err := s.api.GetOneOrder(buyOrd.OrderID2, buyOrd.Currency)
-				if err != nil {
-					panic(err)
-				}
-				buyOrd = _ord
+				log.Println(ord)
+				s.buyOrd = ord
 			}
-
-			if buyOrd != nil && buyOrd.Status == goex.ORDER_FINISH {
-				log.Println(buyOrd)
-				buyCount++
-				buyOrd = nil
-			} else if buyOrd != nil {
-				offset := (dep.AskList[len(dep.AskList)-1].Price - buyOrd.Price) / buyOrd.Price * 100
-				if offset >= buyOffset {
-					log.Println("[cancel] ", buyOrd.OrderID2)
-					_, err := s.api.CancelOrder(buyOrd.OrderID2, goex.BTC_USDT)
-					if err != nil {
-						log.Println("[ERROR] cancel error=", err)
-						log.Println(s.api.GetOneOrder(buyOrd.OrderID2, goex.BTC_USDT))
-					}
-					buyOrd = nil
-				}
+		}
+	} else {
+		offset := (askItem.Price - s.buyOrd.Price) / s.buyOrd.Price * 100
+		if offset >= s.buyOffset {
+			log.Println("[cancel] ", s.buyOrd.OrderID2)
+			_, err := s.api.CancelOrder(s.buyOrd.OrderID2, goex.BTC_USDT)
+			if err != nil {
+				log.Println("[ERROR] cancel error=", err)
+				log.Println(s.api.GetOneOrder(s.buyOrd.OrderID2, goex.BTC_USDT))
 			}
-
-		end:
+			s.buyOrd = nil
 		}
 	}
 }
+
+func (s *SampleStragtegy) OnOrderUpdate(order goex.Order) {
+	if order.Status != goex.ORDER_FINISH {
+		return
+	}
+
+	log.Println(order)
+	if s.sellOrd != nil && s.sellOrd.OrderID2 == order.OrderID2 {
+		s.sellCount++
+		s.sellOrd = nil //reset
+	}
+	if s.buyOrd != nil && s.buyOrd.OrderID2 == order.OrderID2 {
+		s.buyCount++
+		s.buyOrd = nil
+	}
+}
+
+func (s *SampleStragtegy) OnFinish() {
+	log.Println("=========== 买:", s.buyCount, "==========")
+	log.Println("=========== 卖:", s.sellCount, "==========")
+}
@@ -28,6 +28,24 @@ func LoadTomlConfig(tomlFile string) (model.ExchangeSimConfig, error) {
 			DepthSize            int  //回测多少档深度
 			UnGzip               bool //是否解压
 			BackTestDataType     model.BackTestDataType
+			MarketOrderSlippage  float64
+			QueuePositionModel   bool
+			KlineVolumeRatio     float64
+			KlinePath            model.KlinePathType
+			OrderLatency         int64                        //下单延迟(毫秒)
+			MarketDataLatency    int64                        //行情延迟(毫秒)
+			TradingRules         map[string]model.TradingRule `toml:"trading_rules"`
+			Leverage             float64
+			ContractValue        float64
+			MaintMarginRate      float64 `toml:"maintenanceMarginRate"`
+			LiquidationFeeRate   float64
+			MarginTrade          bool
+			MarginLeverage       float64
+			MarginInterestRate   float64
+			WithdrawFee          map[string]float64 `toml:"withdraw_fee"`
+			WithdrawDelay        int64              //提币到账时间(毫秒)
+			CostMethod           model.CostMethodType
+			SnapshotInterval     int64 //自动净值快照间隔(毫秒)
 		}
 	)
 
@@ -45,6 +63,25 @@ func LoadTomlConfig(tomlFile string) (model.ExchangeSimConfig, error) {
 	simConfig.BackTestEndTime = tomlConfig.BackTestEndTime
 	simConfig.BackTestStartTime = tomlConfig.BackTestStartTime
 	simConfig.BackTestData = tomlConfig.BackTestDataType
+	simConfig.MarketOrderSlippage = tomlConfig.MarketOrderSlippage
+	simConfig.QueuePositionModel = tomlConfig.QueuePositionModel
+	simConfig.KlineVolumeRatio = tomlConfig.KlineVolumeRatio
+	simConfig.KlinePath = tomlConfig.KlinePath
+	simConfig.OrderLatencyMs = tomlConfig.OrderLatency
+	simConfig.MarketDataLatency = time.Duration(tomlConfig.MarketDataLatency) * time.Millisecond
+
+	simConfig.TradingRules = tomlConfig.TradingRules
+	simConfig.Leverage = tomlConfig.Leverage
+	simConfig.ContractValue = tomlConfig.ContractValue
+	simConfig.MaintenanceMarginRate = tomlConfig.MaintMarginRate
+	simConfig.LiquidationFeeRate = tomlConfig.LiquidationFeeRate
+	simConfig.MarginTrade = tomlConfig.MarginTrade
+	simConfig.MarginLeverage = tomlConfig.MarginLeverage
+	simConfig.MarginInterestRate = tomlConfig.MarginInterestRate
+	simConfig.WithdrawFee = tomlConfig.WithdrawFee
+	simConfig.WithdrawDelay = time.Duration(tomlConfig.WithdrawDelay) * time.Millisecond
+	simConfig.CostMethod = tomlConfig.CostMethod
+	simConfig.SnapshotInterval = time.Duration(tomlConfig.SnapshotInterval) * time.Millisecond
 
 	for _, pair := range tomlConfig.SupportCurrencyPairs {
 		simConfig.SupportCurrencyPairs = append(simConfig.SupportCurrencyPairs, goex.NewCurrencyPair2(pair))